@@ -0,0 +1,117 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// adminAuthenticator authenticates the requests to protected admin endpoints.  A request is authenticated by bearer token, or by
+// verified client certificate.
+type adminAuthenticator struct {
+	// token is the bearer token.  If it is empty, bearer token authentication is disabled.
+	token string
+	// clientCert is true if verified client certificate authenticates a request.
+	clientCert bool
+}
+
+// newAdminAuthenticator returns new adminAuthenticator.  If tokenFile is not empty, bearer token is read from it.  If clientCert is true,
+// verified client certificate authenticates a request.
+func newAdminAuthenticator(tokenFile string, clientCert bool) (*adminAuthenticator, error) {
+	a := &adminAuthenticator{
+		clientCert: clientCert,
+	}
+
+	if tokenFile != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token file: %v", err)
+		}
+		a.token = strings.TrimSpace(string(b))
+		if a.token == "" {
+			return nil, fmt.Errorf("token file %v is empty", tokenFile)
+		}
+	}
+
+	return a, nil
+}
+
+// enabled returns true if any authentication method is configured.
+func (a *adminAuthenticator) enabled() bool {
+	return a.token != "" || a.clientCert
+}
+
+// authenticate returns nil if r is authenticated.
+func (a *adminAuthenticator) authenticate(r *http.Request) error {
+	if a.clientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return nil
+	}
+
+	if a.token != "" {
+		const prefix = "Bearer "
+		h := r.Header.Get("Authorization")
+		if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) &&
+			subtle.ConstantTimeCompare([]byte(h[len(prefix):]), []byte(a.token)) == 1 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unauthorized")
+}
+
+// protect returns http.Handler which serves h only for authenticated requests.  If no authentication method is configured, all requests
+// are rejected.
+func (a *adminAuthenticator) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.enabled() {
+			http.Error(w, "admin authentication is not configured", http.StatusForbidden)
+			return
+		}
+		if err := a.authenticate(r); err != nil {
+			if a.token != "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newAdminTLSConfig returns tls.Config for admin endpoints.  If clientCAFile is not empty, client certificate is verified against the CA
+// certificates in it.  Client certificate is optional, so that health checks work without it.
+func newAdminTLSConfig(clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	b, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no CA certificate found in %v", clientCAFile)
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return config, nil
+}
@@ -0,0 +1,89 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAdminAuthenticatorProtect verifies that protected handler is served only for authenticated requests.
+func TestAdminAuthenticatorProtect(t *testing.T) {
+	tests := []struct {
+		desc       string
+		auth       *adminAuthenticator
+		header     string
+		clientCert bool
+		wantCode   int
+	}{
+		{
+			desc:     "no authentication is configured",
+			auth:     &adminAuthenticator{},
+			header:   "Bearer secret",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			desc:     "valid token",
+			auth:     &adminAuthenticator{token: "secret"},
+			header:   "Bearer secret",
+			wantCode: http.StatusOK,
+		},
+		{
+			desc:     "invalid token",
+			auth:     &adminAuthenticator{token: "secret"},
+			header:   "Bearer secret2",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "no token",
+			auth:     &adminAuthenticator{token: "secret"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			desc:       "client certificate is not accepted unless configured",
+			auth:       &adminAuthenticator{token: "secret"},
+			clientCert: true,
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			desc:       "verified client certificate",
+			auth:       &adminAuthenticator{clientCert: true},
+			clientCert: true,
+			wantCode:   http.StatusOK,
+		},
+		{
+			desc:     "no client certificate",
+			auth:     &adminAuthenticator{clientCert: true},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		h := tt.auth.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodGet, "/stop", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if tt.clientCert {
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+			}
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if got, want := w.Code, tt.wantCode; got != want {
+			t.Errorf("%v: w.Code = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
@@ -26,6 +26,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -41,6 +44,7 @@ import (
 	"github.com/spf13/pflag"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apiserver/pkg/server/healthz"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -75,7 +79,11 @@ var (
 		`Relist and confirm cloud resources this often.`)
 
 	watchNamespace = flags.String("watch-namespace", metav1.NamespaceAll,
-		`Namespace to watch for Ingress. Default is to watch all namespaces`)
+		`Namespace to watch for Ingress.  It can be a comma separated list of namespaces.  Default is to watch all namespaces`)
+
+	watchNamespaceSelector = flags.String("watch-namespace-selector", "",
+		`Label selector of namespaces to watch for Ingress.  If --watch-namespace is also given, a namespace must satisfy both of them.
+                Namespaces are picked up as they are labeled or unlabeled.`)
 
 	healthzPort = flags.Int("healthz-port", 11249, "port for healthz endpoint.")
 
@@ -85,7 +93,21 @@ var (
 
 	buildCfg = flags.Bool("dump-nghttpx-configuration", false, `Deprecated`)
 
-	profiling = flags.Bool("profiling", true, `Enable profiling via web interface host:port/debug/pprof/`)
+	profiling = flags.Bool("profiling", false, `Enable profiling via web interface host:port/debug/pprof/.  It requires admin authentication.`)
+
+	adminTokenFile = flags.String("admin-token-file", "",
+		`Path to a file which contains bearer token to access protected admin endpoints, such as /stop, /debug/config, and
+                /debug/pprof/.  If neither this flag nor --admin-client-ca-file is given, protected admin endpoints are disabled.`)
+
+	adminTLSCertFile = flags.String("admin-tls-cert-file", "",
+		`Path to TLS server certificate file to serve admin endpoints including healthz over HTTPS.  --admin-tls-key-file must also
+                be given.`)
+
+	adminTLSKeyFile = flags.String("admin-tls-key-file", "", `Path to TLS private key file for --admin-tls-cert-file.`)
+
+	adminClientCAFile = flags.String("admin-client-ca-file", "",
+		`Path to CA certificate file to verify client certificate.  A request with verified client certificate can access protected
+                admin endpoints.  This requires --admin-tls-cert-file.`)
 
 	allowInternalIP = flags.Bool("allow-internal-ip", false, `Allow to use address of type NodeInternalIP when fetching
                 external IP address. This is the workaround for the cluster configuration where NodeExternalIP or
@@ -109,11 +131,108 @@ var (
 	nghttpxHTTPSPort = flags.Int("nghttpx-https-port", 443,
 		`Port to listen to for HTTPS (TLS) requests.`)
 
+	nghttpxFrontends = flags.StringArray("nghttpx-frontend", nil,
+		`Frontend to listen to in the form of <HOST>,<PORT>[;tls][;proxyproto].  HOST is * or IP address.  This flag can be repeated.  If
+                it is given, --nghttpx-http-port and --nghttpx-https-port are ignored.  "frontends" key in ConfigMap overrides this flag.`)
+
+	listenTLSFrontendWithoutTLS = flags.Bool("listen-tls-frontend-without-tls", false,
+		`Listen to TLS frontends for cleartext connections when no TLS certificate is configured.  Without this flag, TLS frontends are
+                not opened in that case.`)
+
 	fetchOCSPRespFromSecret = flags.Bool("fetch-ocsp-resp-from-secret", false,
 		`Fetch OCSP response from TLS secret.`)
 
 	ocspRespKey = flags.String("ocsp-resp-key", "tls.ocsp-resp", `A key for OCSP response in TLS secret.`)
 
+	clusterDomain = flags.String("cluster-domain", "cluster.local",
+		`DNS domain of the cluster.  It is used to construct the default SNI host name of backend TLS connection.`)
+
+	backendCANamespaces = flags.String("backend-ca-namespaces", "",
+		`Comma separated list of namespaces whose Ingresses may reference backend CA certificate with caSecret.  nghttpx has only one
+                CA certificate bundle, so that a CA certificate referenced by any Ingress is trusted for all backends.  Default is to
+                allow all namespaces.`)
+
+	shareTLSTicketKey = flags.Bool("share-tls-ticket-key", false,
+		`Share TLS session ticket keys among Ingress controllers.  The leader generates and rotates keys, and stores them in Secret
+                nghttpx-km in the same namespace as the controller.  This requires POD_NAMESPACE and POD_NAME to be set.`)
+
+	tlsTicketKeyPeriod = flags.Duration("tls-ticket-key-period", time.Hour,
+		`Rotation interval of TLS session ticket keys when --share-tls-ticket-key is enabled.`)
+
+	strictNghttpxConf = flags.Bool("strict-nghttpx-conf", false,
+		`Allow only known safe options in nghttpx-conf in ConfigMap.  Otherwise, all options except for those managed by the controller
+                are allowed.`)
+
+	enableOutlierDetection = flags.Bool("enable-outlier-detection", false,
+		`Eject backend endpoints which return too many 5xx responses.  The error rate of each endpoint is computed from nghttpx access
+                log.`)
+
+	outlierDetectionWindow = flags.Duration("outlier-detection-window", 30*time.Second,
+		`Duration of sliding window over which the error rate of an endpoint is computed for outlier detection.`)
+
+	outlierDetectionErrorRate = flags.Float64("outlier-detection-error-rate", 0.5,
+		`Ratio of 5xx responses in [0, 1] at or above which an endpoint is ejected.`)
+
+	outlierDetectionMinRequests = flags.Int("outlier-detection-min-requests", 10,
+		`Minimum number of requests in the window before an endpoint is considered for ejection.`)
+
+	outlierDetectionEjectionDuration = flags.Duration("outlier-detection-ejection-duration", 30*time.Second,
+		`Duration that an ejected endpoint is removed from backends.`)
+
+	outlierDetectionMaxEjectionPercent = flags.Int("outlier-detection-max-ejection-percent", 50,
+		`Maximum percentage of endpoints of a Service port which can be ejected at the same time.`)
+
+	drainPeriod = flags.Duration("drain-period", 0,
+		`Duration to wait after this address is removed from Ingress status on shutdown before nghttpx is stopped.  During this
+                period, readyz endpoint fails, and nghttpx keeps serving requests, so that external load balancers stop sending new
+                requests.  healthz endpoint keeps succeeding, so that liveness probe does not restart the container during drain.`)
+
+	nghttpxQuitTimeout = flags.Duration("nghttpx-quit-timeout", 30*time.Second,
+		`Maximum duration to wait for nghttpx to exit gracefully on shutdown.  nghttpx is killed after this duration.  0 means no
+                timeout.`)
+
+	readinessMaxSyncAge = flags.Duration("readiness-max-sync-age", 5*time.Minute,
+		`Maximum duration since the last successful sync while sync keeps failing before readyz endpoint fails.  0 disables this
+                check.`)
+
+	ingressStatusAnnotation = flags.Bool("ingress-status-annotation", false,
+		`Write the programming status of each Ingress to ingress.zlab.co.jp/status annotation.  Only the leader writes them.  This
+                requires POD_NAMESPACE and POD_NAME to be set.`)
+
+	syncDebounceWindow = flags.Duration("sync-debounce-window", 200*time.Millisecond,
+		`Duration to wait for further changes of resources before generating nghttpx configuration.  Changes within this window are
+                coalesced into one update.  0 disables debouncing.`)
+
+	syncMaxDelay = flags.Duration("sync-max-delay", 2*time.Second,
+		`Maximum duration that generating nghttpx configuration is delayed by --sync-debounce-window since the first change.`)
+
+	reloadMinInterval = flags.Duration("reload-min-interval", 5*time.Second,
+		`Minimum interval between reloads of nghttpx main configuration, which spawn new worker processes.  0 disables the limit.`)
+
+	backendUpdateMinInterval = flags.Duration("backend-update-min-interval", time.Second,
+		`Minimum interval between updates of nghttpx backend configuration through API.  0 disables the limit.`)
+
+	syncRetryBaseDelay = flags.Duration("sync-retry-base-delay", time.Second,
+		`Delay before the first retry when updating nghttpx configuration fails.  The delay doubles on each consecutive failure.`)
+
+	syncRetryMaxDelay = flags.Duration("sync-retry-max-delay", 2*time.Minute,
+		`Maximum delay between retries when updating nghttpx configuration fails.`)
+
+	syncMaxRetries = flags.Int("sync-max-retries", 10,
+		`Maximum number of consecutive retries when updating nghttpx configuration fails.  After that, it is not retried until the
+                next change of resources or resync.  0 means no limit.`)
+
+	enableTopologyAwareRouting = flags.Bool("enable-topology-aware-routing", false,
+		`Prefer backend endpoints in the same zone as the controller.  The zone is read from topology.kubernetes.io/zone or
+                failure-domain.beta.kubernetes.io/zone label of Nodes.  This requires POD_NAMESPACE and POD_NAME to be set.`)
+
+	topologyMinLocalEndpoints = flags.Int("topology-min-local-endpoints", 1,
+		`Minimum number of healthy endpoints in the local zone.  If the local zone has fewer, endpoints in all zones are used.`)
+
+	topologyMinLocalHealthyFraction = flags.Float64("topology-min-local-healthy-fraction", 0.5,
+		`Minimum ratio in [0, 1] of healthy endpoints to all endpoints in the local zone.  If the ratio is lower, endpoints in all
+                zones are used.`)
+
 	configOverrides clientcmd.ConfigOverrides
 )
 
@@ -121,6 +240,10 @@ func main() {
 	// We use math/rand to choose interval of resync
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	if len(os.Args) > 1 && os.Args[1] == "route" {
+		os.Exit(runRouteCommand(os.Args[2:]))
+	}
+
 	flags.AddGoFlagSet(flag.CommandLine)
 
 	clientcmd.BindOverrideFlags(&configOverrides, flags, clientcmd.RecommendedConfigOverrideFlags(""))
@@ -177,6 +300,80 @@ func main() {
 		}
 	}
 
+	var nsSelector labels.Selector
+	if *watchNamespaceSelector != "" {
+		nsSelector, err = labels.Parse(*watchNamespaceSelector)
+		if err != nil {
+			glog.Exitf("could not parse --watch-namespace-selector %v: %v", *watchNamespaceSelector, err)
+		}
+	}
+
+	var frontends []nghttpx.Frontend
+	for _, s := range *nghttpxFrontends {
+		fe, err := nghttpx.ParseFrontend(s)
+		if err != nil {
+			glog.Exitf("could not parse --nghttpx-frontend: %v", err)
+		}
+		frontends = append(frontends, fe)
+	}
+
+	if (*adminTLSCertFile == "") != (*adminTLSKeyFile == "") {
+		glog.Exit("--admin-tls-cert-file and --admin-tls-key-file must be given together")
+	}
+	if *adminClientCAFile != "" && *adminTLSCertFile == "" {
+		glog.Exit("--admin-client-ca-file requires --admin-tls-cert-file")
+	}
+
+	adminAuth, err := newAdminAuthenticator(*adminTokenFile, *adminClientCAFile != "")
+	if err != nil {
+		glog.Exitf("could not configure admin authentication: %v", err)
+	}
+
+	var adminTLSConfig *tls.Config
+	if *adminTLSCertFile != "" {
+		adminTLSConfig, err = newAdminTLSConfig(*adminClientCAFile)
+		if err != nil {
+			glog.Exitf("could not configure admin TLS: %v", err)
+		}
+	}
+
+	var outlierDetection *controller.OutlierDetectionConfig
+	if *enableOutlierDetection {
+		if *outlierDetectionWindow <= 0 {
+			glog.Exitf("--outlier-detection-window must be positive: %v", *outlierDetectionWindow)
+		}
+		if *outlierDetectionErrorRate < 0 || *outlierDetectionErrorRate > 1 {
+			glog.Exitf("--outlier-detection-error-rate must be in [0, 1]: %v", *outlierDetectionErrorRate)
+		}
+		if *outlierDetectionEjectionDuration <= 0 {
+			glog.Exitf("--outlier-detection-ejection-duration must be positive: %v", *outlierDetectionEjectionDuration)
+		}
+		if *outlierDetectionMaxEjectionPercent < 0 || *outlierDetectionMaxEjectionPercent > 100 {
+			glog.Exitf("--outlier-detection-max-ejection-percent must be in [0, 100]: %v", *outlierDetectionMaxEjectionPercent)
+		}
+		outlierDetection = &controller.OutlierDetectionConfig{
+			Window:             *outlierDetectionWindow,
+			ErrorRate:          *outlierDetectionErrorRate,
+			MinRequests:        *outlierDetectionMinRequests,
+			EjectionDuration:   *outlierDetectionEjectionDuration,
+			MaxEjectionPercent: *outlierDetectionMaxEjectionPercent,
+		}
+	}
+
+	var topology *controller.TopologyConfig
+	if *enableTopologyAwareRouting {
+		if *topologyMinLocalEndpoints < 0 {
+			glog.Exitf("--topology-min-local-endpoints must not be negative: %v", *topologyMinLocalEndpoints)
+		}
+		if *topologyMinLocalHealthyFraction < 0 || *topologyMinLocalHealthyFraction > 1 {
+			glog.Exitf("--topology-min-local-healthy-fraction must be in [0, 1]: %v", *topologyMinLocalHealthyFraction)
+		}
+		topology = &controller.TopologyConfig{
+			MinLocalEndpoints:       *topologyMinLocalEndpoints,
+			MinLocalHealthyFraction: *topologyMinLocalHealthyFraction,
+		}
+	}
+
 	runtimePodInfo := &controller.PodInfo{
 		PodName:      os.Getenv("POD_NAME"),
 		PodNamespace: os.Getenv("POD_NAMESPACE"),
@@ -190,30 +387,56 @@ func main() {
 	}
 
 	controllerConfig := controller.Config{
-		ResyncPeriod:            *resyncPeriod,
-		DefaultBackendService:   *defaultSvc,
-		WatchNamespace:          *watchNamespace,
-		NghttpxConfigMap:        *ngxConfigMap,
-		NghttpxHealthPort:       *nghttpxHealthPort,
-		NghttpxAPIPort:          *nghttpxAPIPort,
-		NghttpxConfDir:          *nghttpxConfDir,
-		NghttpxExecPath:         *nghttpxExecPath,
-		NghttpxHTTPPort:         *nghttpxHTTPPort,
-		NghttpxHTTPSPort:        *nghttpxHTTPSPort,
-		DefaultTLSSecret:        *defaultTLSSecret,
-		IngressClass:            *ingressClass,
-		AllowInternalIP:         *allowInternalIP,
-		OCSPRespKey:             *ocspRespKey,
-		FetchOCSPRespFromSecret: *fetchOCSPRespFromSecret,
+		ResyncPeriod:                *resyncPeriod,
+		DefaultBackendService:       *defaultSvc,
+		WatchNamespace:              *watchNamespace,
+		WatchNamespaceSelector:      nsSelector,
+		NghttpxConfigMap:            *ngxConfigMap,
+		NghttpxHealthPort:           *nghttpxHealthPort,
+		NghttpxAPIPort:              *nghttpxAPIPort,
+		NghttpxConfDir:              *nghttpxConfDir,
+		NghttpxExecPath:             *nghttpxExecPath,
+		NghttpxHTTPPort:             *nghttpxHTTPPort,
+		NghttpxHTTPSPort:            *nghttpxHTTPSPort,
+		NghttpxFrontends:            frontends,
+		ListenTLSFrontendWithoutTLS: *listenTLSFrontendWithoutTLS,
+		DefaultTLSSecret:            *defaultTLSSecret,
+		IngressClass:                *ingressClass,
+		AllowInternalIP:             *allowInternalIP,
+		OCSPRespKey:                 *ocspRespKey,
+		FetchOCSPRespFromSecret:     *fetchOCSPRespFromSecret,
+		ClusterDomain:               *clusterDomain,
+		BackendCANamespaces:         *backendCANamespaces,
+		ShareTLSTicketKey:           *shareTLSTicketKey,
+		TLSTicketKeyPeriod:          *tlsTicketKeyPeriod,
+		StrictNghttpxConf:           *strictNghttpxConf,
+		OutlierDetection:            outlierDetection,
+		Topology:                    topology,
+		DrainPeriod:                 *drainPeriod,
+		NghttpxQuitTimeout:          *nghttpxQuitTimeout,
+		ReadinessMaxSyncAge:         *readinessMaxSyncAge,
+		IngressStatusAnnotation:     *ingressStatusAnnotation,
+		SyncDebounceWindow:          *syncDebounceWindow,
+		SyncMaxDelay:                *syncMaxDelay,
+		ReloadMinInterval:           *reloadMinInterval,
+		BackendUpdateMinInterval:    *backendUpdateMinInterval,
+		SyncRetryBaseDelay:          *syncRetryBaseDelay,
+		SyncRetryMaxDelay:           *syncRetryMaxDelay,
+		SyncMaxRetries:              *syncMaxRetries,
 	}
 
 	if err := generateDefaultNghttpxConfig(*nghttpxConfDir, *nghttpxHealthPort, *nghttpxAPIPort); err != nil {
 		glog.Exit(err)
 	}
 
-	lbc := controller.NewLoadBalancerController(clientset, nghttpx.NewManager(*nghttpxAPIPort), &controllerConfig, runtimePodInfo)
+	mgr := nghttpx.NewManager(*nghttpxAPIPort)
+	lbc := controller.NewLoadBalancerController(clientset, mgr, &controllerConfig, runtimePodInfo)
 
-	go registerHandlers(lbc)
+	if w := lbc.AccessLogWriter(); w != nil {
+		mgr.SetAccessLogWriter(w)
+	}
+
+	go registerHandlers(lbc, mgr, adminAuth, adminTLSConfig)
 	go handleSigterm(lbc)
 
 	lbc.Run()
@@ -252,28 +475,78 @@ func (hc healthzChecker) Check(_ *http.Request) error {
 	return nil
 }
 
-func registerHandlers(lbc *controller.LoadBalancerController) {
+// newReadyzHandler returns http.Handler which serves readiness check.  Unlike healthz, the reason of the failed check is written to the
+// response body.
+func newReadyzHandler(checks ...healthz.HealthzChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range checks {
+			if err := check.Check(r); err != nil {
+				http.Error(w, fmt.Sprintf("%v failed: %v", check.Name(), err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
+
+// registerHandlers serves admin endpoints.  Protected endpoints require authentication by auth.  If tlsConfig is not nil, they are
+// served over HTTPS.
+func registerHandlers(lbc *controller.LoadBalancerController, mgr *nghttpx.Manager, auth *adminAuthenticator, tlsConfig *tls.Config) {
 	mux := http.NewServeMux()
+	// Liveness check must not fail while shutting down.  Otherwise, kubelet might restart the container during drain.  readyz fails
+	// instead.
 	healthz.InstallHandler(mux, newHealthzChecker(*nghttpxHealthPort))
 
-	http.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/readyz", newReadyzHandler(
+		newHealthzChecker(*nghttpxHealthPort),
+		healthz.NamedCheck("controller", func(_ *http.Request) error {
+			return lbc.Ready()
+		}),
+	))
+
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "build: %v - %v", gitRepo, version)
+		fmt.Fprintf(w, "build: %v - %v\n%v\n", gitRepo, version, mgr.Capabilities())
 	})
 
-	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/debug/vars", auth.protect(expvar.Handler()))
+
+	mux.Handle("/stop", auth.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		lbc.Stop()
-	})
+	})))
+
+	mux.Handle("/debug/config", auth.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ingConfig := lbc.IngressConfig()
+		if ingConfig == nil {
+			http.Error(w, "configuration has not been applied yet", http.StatusServiceUnavailable)
+			return
+		}
+		b, err := json.MarshalIndent(ingConfig, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})))
+
+	mux.Handle("/debug/route", auth.protect(newRouteHandler(lbc)))
 
 	if *profiling {
-		mux.HandleFunc("/debug/pprof/", pprof.Index)
-		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.Handle("/debug/pprof/", auth.protect(http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", auth.protect(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", auth.protect(http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", auth.protect(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", auth.protect(http.HandlerFunc(pprof.Trace)))
 	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%v", *healthzPort),
-		Handler: mux,
+		Addr:      fmt.Sprintf(":%v", *healthzPort),
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil {
+		glog.Exit(server.ListenAndServeTLS(*adminTLSCertFile, *adminTLSKeyFile))
 	}
 	glog.Exit(server.ListenAndServe())
 }
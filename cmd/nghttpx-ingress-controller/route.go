@@ -0,0 +1,170 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/controller"
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+// newRouteHandler returns http.Handler which looks up the upstream serving the request with host and path given in query parameters.
+func newRouteHandler(lbc *controller.LoadBalancerController) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if host == "" {
+			http.Error(w, "host query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		ingConfig := lbc.IngressConfig()
+		if ingConfig == nil {
+			http.Error(w, "configuration has not been applied yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		b, err := json.MarshalIndent(nghttpx.LookupRoute(ingConfig.Upstreams, host, r.URL.Query().Get("path")), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// runRouteCommand runs route subcommand, which asks the running controller which upstream serves the request with the given host and
+// path.  It returns the exit status.
+func runRouteCommand(args []string) int {
+	flags := pflag.NewFlagSet("route", pflag.ContinueOnError)
+
+	host := flags.String("host", "", `(Required) Host of the request.`)
+	path := flags.String("path", "/", `Path of the request.`)
+	server := flags.String("server", "http://127.0.0.1:11249", `URI of the controller admin endpoint.`)
+	tokenFile := flags.String("token-file", "", `Path to a file which contains bearer token to access the admin endpoint.`)
+	insecureSkipTLSVerify := flags.Bool("insecure-skip-tls-verify", false, `Do not verify the server certificate of the admin endpoint.`)
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *host == "" {
+		fmt.Fprintln(os.Stderr, "--host is required")
+		return 2
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/debug/route?%v", strings.TrimSuffix(*server, "/"), url.Values{
+		"host": {*host},
+		"path": {*path},
+	}.Encode()), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read token file: %v\n", err)
+			return 1
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: *insecureSkipTLSVerify,
+			},
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%v: %v", resp.Status, string(b))
+		return 1
+	}
+
+	var res nghttpx.RouteLookupResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not parse response: %v\n", err)
+		return 1
+	}
+
+	printRouteLookupResult(os.Stdout, &res)
+
+	return 0
+}
+
+// printRouteLookupResult writes res to w in human readable format.
+func printRouteLookupResult(w io.Writer, res *nghttpx.RouteLookupResult) {
+	fmt.Fprintf(w, "Request:      %v%v\n", res.Host, res.Path)
+
+	if res.Upstream == "" {
+		fmt.Fprintf(w, "Upstream:     <none>\n")
+	} else {
+		source := res.Source
+		if source == "" {
+			source = "<controller default backend>"
+		}
+		fmt.Fprintf(w, "Upstream:     %v\n", res.Upstream)
+		fmt.Fprintf(w, "Ingress:      %v\n", source)
+		fmt.Fprintf(w, "Pattern:      %v\n", res.Pattern)
+		fmt.Fprintf(w, "TLS redirect: %v\n", res.RedirectIfNotTLS)
+		fmt.Fprintf(w, "Backends:\n")
+		for _, backend := range res.Backends {
+			fmt.Fprintf(w, "  %v:%v proto=%v", backend.Address, backend.Port, backend.Protocol)
+			if backend.TLS {
+				fmt.Fprintf(w, " tls")
+			}
+			if backend.SNI != "" {
+				fmt.Fprintf(w, " sni=%v", backend.SNI)
+			}
+			if backend.DNS {
+				fmt.Fprintf(w, " dns")
+			}
+			if backend.Zone != "" {
+				fmt.Fprintf(w, " zone=%v", backend.Zone)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	if len(res.NearMisses) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "Near misses:\n")
+	for _, nm := range res.NearMisses {
+		source := nm.Source
+		if source == "" {
+			source = "<controller default backend>"
+		}
+		fmt.Fprintf(w, "  %v (Ingress %v): %v\n", nm.Pattern, source, nm.Reason)
+	}
+}
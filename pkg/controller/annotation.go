@@ -10,6 +10,7 @@ package controller
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/golang/glog"
 
@@ -21,6 +22,14 @@ const (
 	backendConfigKey = "ingress.zlab.co.jp/backend-config"
 	// ingressClassKey is a key to annotation in order to run multiple Ingress controllers.
 	ingressClassKey = "kubernetes.io/ingress.class"
+	// healthCheckKey is a key to annotation of Service which configures active health checking of its endpoints.
+	healthCheckKey = "ingress.zlab.co.jp/health-check"
+	// statusKey is a key to annotation which records whether the Ingress is programmed into nghttpx.  Its value is a serialized JSON
+	// dictionary.
+	statusKey = "ingress.zlab.co.jp/status"
+	// allowHTTPKey is a key to annotation of Ingress which allows the hosts not listed in .spec.tls[*].hosts to be served via cleartext
+	// HTTP.
+	allowHTTPKey = "ingress.zlab.co.jp/allow-http"
 )
 
 type ingressAnnotation map[string]string
@@ -44,3 +53,19 @@ func (ia ingressAnnotation) getBackendConfig() map[string]map[string]nghttpx.Por
 func (ia ingressAnnotation) getIngressClass() string {
 	return ia[ingressClassKey]
 }
+
+// getAllowHTTP returns true if the hosts which are not listed in .spec.tls[*].hosts are allowed to be served via cleartext HTTP.  It
+// returns false if the annotation is missing or invalid.
+func (ia ingressAnnotation) getAllowHTTP() bool {
+	data := ia[allowHTTPKey]
+	if data == "" {
+		return false
+	}
+	allow, err := strconv.ParseBool(data)
+	if err != nil {
+		glog.Errorf("unexpected error reading %v annotation: %v", allowHTTPKey, err)
+		return false
+	}
+
+	return allow
+}
@@ -25,7 +25,9 @@ limitations under the License.
 package controller
 
 import (
+	"expvar"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"reflect"
@@ -42,10 +44,11 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
 	clientv1 "k8s.io/client-go/pkg/api/v1"
 	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
@@ -65,6 +68,35 @@ const (
 	// syncKey is a key to put into the queue.  Since we create load balancer configuration using all available information, it is
 	// suffice to queue only one item.  Further, queue is somewhat overkill here, but we just keep using it for simplicity.
 	syncKey = "ingress"
+	// defaultSyncRetryBaseDelay is the delay before the first retry of failed sync unless Config.SyncRetryBaseDelay is specified.
+	defaultSyncRetryBaseDelay = time.Second
+	// defaultSyncRetryMaxDelay is the maximum delay between retries of failed sync unless Config.SyncRetryMaxDelay is specified.
+	defaultSyncRetryMaxDelay = 2 * time.Minute
+	// caCertKey is the key of CA certificate in Secret.
+	caCertKey = "ca.crt"
+	// tlsTicketKeySecretName is the name of Secret which contains TLS session ticket keys shared by controllers.  It resides in the
+	// same namespace as the controller.
+	tlsTicketKeySecretName = "nghttpx-km"
+	// tlsTicketKeyKey is the key of TLS session ticket keys in Secret.
+	tlsTicketKeyKey = "nghttpx-ticket-key"
+	// tlsTicketKeyUpdateTimestampKey is a key to annotation of Secret which records the time when TLS session ticket keys were last
+	// rotated.
+	tlsTicketKeyUpdateTimestampKey = "ingress.zlab.co.jp/update-timestamp"
+	// tlsTicketKeyMaxKeys is the maximum number of TLS session ticket keys to keep.  The keys other than the first one are kept for
+	// decryption.  The second key is the next encryption key, and the remaining keys are the previous encryption keys.  This is the same
+	// number of keys that nghttpx keeps when it rotates keys by itself.
+	tlsTicketKeyMaxKeys = 12
+	// tlsTicketKeyCheckPeriod is the interval that the leader checks whether TLS session ticket keys should be rotated.
+	tlsTicketKeyCheckPeriod = time.Minute
+	// outlierDetectionEvaluatePeriod is the interval that outlier detector evaluates the error rate of endpoints.
+	outlierDetectionEvaluatePeriod = time.Second
+)
+
+var (
+	// syncRetries is the number of retries of failed sync.
+	syncRetries = expvar.NewInt("syncRetries")
+	// syncRetriesExhausted is the number of failed syncs which are not retried because the maximum number of retries is reached.
+	syncRetriesExhausted = expvar.NewInt("syncRetriesExhausted")
 )
 
 // LoadBalancerController watches the kubernetes api and adds/removes services
@@ -78,6 +110,7 @@ type LoadBalancerController struct {
 	cmController            cache.Controller
 	podController           cache.Controller
 	nodeController          cache.Controller
+	nsController            cache.Controller
 	ingLister               *ingressLister
 	svcLister               *serviceLister
 	epLister                *endpointsLister
@@ -85,6 +118,7 @@ type LoadBalancerController struct {
 	cmLister                *configMapLister
 	podLister               *podLister
 	nodeLister              *nodeLister
+	nsLister                *namespaceLister
 	nghttpx                 nghttpx.Interface
 	podInfo                 *PodInfo
 	defaultSvc              string
@@ -93,30 +127,98 @@ type LoadBalancerController struct {
 	nghttpxAPIPort          int
 	nghttpxConfDir          string
 	nghttpxExecPath         string
-	nghttpxHTTPPort         int
-	nghttpxHTTPSPort        int
+	frontends               []nghttpx.Frontend
+	listenTLSWithoutTLS     bool
 	defaultTLSSecret        string
-	watchNamespace          string
 	ingressClass            string
 	allowInternalIP         bool
 	ocspRespKey             string
 	fetchOCSPRespFromSecret bool
+	clusterDomain           string
+	shareTLSTicketKey       bool
+	tlsTicketKeyPeriod      time.Duration
+	strictNghttpxConf       bool
+
+	// cmEvents is the Event last recorded on the nghttpx ConfigMap keyed by reason.  Only sync accesses it.
+	cmEvents map[string]configMapEvent
+
+	// backendCANamespaces is the set of namespaces whose Ingresses may reference backend CA certificate.  If it is nil, all namespaces
+	// are allowed.
+	backendCANamespaces map[string]bool
+
+	// watchNamespaces is the set of namespaces to watch for Ingress.  If it is nil, all namespaces are watched.
+	watchNamespaces map[string]bool
+	// watchNamespaceSelector selects namespaces to watch for Ingress by label.  If it is nil, namespaces are not filtered by label.
+	watchNamespaceSelector labels.Selector
+
+	// leaderElector elects a leader among controller replicas.  The leader records Ingress Events, writes the status annotation, and
+	// rotates TLS session ticket keys.
+	leaderElector *leaderElector
+	// healthChecker checks the health of endpoints of Services which enable active health checking.
+	healthChecker *healthChecker
+	// outlierDetector ejects endpoints which return too many errors.  It is nil if outlier detection is disabled.
+	outlierDetector *outlierDetector
+	// topology is the configuration of zone-aware routing.  It is nil if zone-aware routing is disabled.
+	topology *TopologyConfig
 
 	recorder record.EventRecorder
 
-	syncQueue workqueue.Interface
+	syncQueue workqueue.RateLimitingInterface
+	// syncMaxRetries is the maximum number of retries of failed sync.  If it is zero, failed sync is retried indefinitely.
+	syncMaxRetries int
 
 	// stopLock is used to enforce only a single call to Stop is active.
 	// Needed because we allow stopping through an http endpoint and
 	// allowing concurrent stoppers leads to stack traces.
 	stopLock sync.Mutex
 	shutdown bool
-	stopCh   chan struct{}
+	// shutdownCh is closed when shutting down commences.  Ingress status is cleaned up, and connections are drained before stopCh is
+	// closed.
+	shutdownCh chan struct{}
+	stopCh     chan struct{}
+	// drainPeriod is the duration to wait after this address is removed from Ingress status before nghttpx is stopped.
+	drainPeriod time.Duration
+	// nghttpxQuitTimeout is the maximum duration to wait for nghttpx to exit gracefully.
+	nghttpxQuitTimeout time.Duration
+
+	// syncStatusMu protects the following fields which record the result of sync.
+	syncStatusMu sync.Mutex
+	// lastSyncTime is the time when sync succeeded last time.  It is zero if sync has never succeeded.
+	lastSyncTime time.Time
+	// lastSyncErr is the error of the last sync.  It is nil if the last sync succeeded.
+	lastSyncErr error
+	// ingConfig is the configuration which was applied to nghttpx by the last successful sync.
+	ingConfig *nghttpx.IngressConfig
+
+	// ingStatusAnnotation, if true, writes the status annotation to Ingresses.
+	ingStatusAnnotation bool
+	// ingStatusMu protects ingStatuses.
+	ingStatusMu sync.Mutex
+	// ingStatuses is the status of Ingresses keyed by namespace/name recorded by the last successful sync.
+	ingStatuses map[string]ingressStatus
+	// reportedIngStatuses is the status of Ingresses keyed by namespace/name which the leader has reported by Events.  It is used
+	// instead of the status annotation to suppress duplicate Events if ingStatusAnnotation is false.  Only
+	// updateIngressStatusAnnotations accesses it.
+	reportedIngStatuses map[string]ingressStatus
+	// ingStatusRateLimiter limits the rate of writes of the status annotation.
+	ingStatusRateLimiter flowcontrol.RateLimiter
+	// readinessMaxSyncAge is the maximum duration since the last successful sync while sync keeps failing before the controller
+	// becomes not ready.
+	readinessMaxSyncAge time.Duration
 
 	// controllersInSyncHandler returns true if all resource controllers have synced.
 	controllersInSyncHandler func() bool
 
-	reloadRateLimiter flowcontrol.RateLimiter
+	// syncDebouncer coalesces the events which request sync.  It is nil if debouncing is disabled.
+	syncDebouncer *syncDebouncer
+	// reloadMinInterval is the minimum interval between reloads of nghttpx main configuration.
+	reloadMinInterval time.Duration
+	// backendUpdateMinInterval is the minimum interval between updates of nghttpx backend configuration.
+	backendUpdateMinInterval time.Duration
+	// lastMainReload is the time when nghttpx main configuration was last reloaded.  It is only accessed from the worker.
+	lastMainReload time.Time
+	// lastBackendUpdate is the time when nghttpx backend configuration was last updated.  It is only accessed from the worker.
+	lastBackendUpdate time.Time
 }
 
 type Config struct {
@@ -124,8 +226,12 @@ type Config struct {
 	ResyncPeriod time.Duration
 	// DefaultBackendService is the default backend service name.
 	DefaultBackendService string
-	// WatchNamespace is the namespace to watch for Ingress resource updates.
+	// WatchNamespace is the namespace to watch for Ingress resource updates.  It can be a comma separated list of namespaces.  If it is
+	// empty, all namespaces are watched.
 	WatchNamespace string
+	// WatchNamespaceSelector selects namespaces to watch for Ingress resource updates by label.  If it is nil, namespaces are not
+	// filtered by label.
+	WatchNamespaceSelector labels.Selector
 	// NghttpxConfigMap is the name of ConfigMap resource which contains additional configuration for nghttpx.
 	NghttpxConfigMap string
 	// NghttpxHealthPort is the port for nghttpx health monitor endpoint.
@@ -136,10 +242,14 @@ type Config struct {
 	NghttpxConfDir string
 	// NghttpxExecPath is a path to nghttpx executable.
 	NghttpxExecPath string
-	// NghttpxHTTPPort is a port to listen to for HTTP (non-TLS) requests.
+	// NghttpxHTTPPort is a port to listen to for HTTP (non-TLS) requests.  It is ignored if NghttpxFrontends is not empty.
 	NghttpxHTTPPort int
-	// NghttpxHTTPSPort is a port to listen to for HTTPS (TLS) requests.
+	// NghttpxHTTPSPort is a port to listen to for HTTPS (TLS) requests.  It is ignored if NghttpxFrontends is not empty.
 	NghttpxHTTPSPort int
+	// NghttpxFrontends is the list of frontends.  If it is empty, frontends are constructed from NghttpxHTTPPort and NghttpxHTTPSPort.
+	NghttpxFrontends []nghttpx.Frontend
+	// ListenTLSFrontendWithoutTLS, if true, makes TLS frontends listen for cleartext connections when TLS is not configured.
+	ListenTLSFrontendWithoutTLS bool
 	// DefaultTLSSecret is the default TLS Secret to enable TLS by default.
 	DefaultTLSSecret string
 	// IngressClass is the Ingress class this controller is responsible for.
@@ -147,46 +257,146 @@ type Config struct {
 	AllowInternalIP         bool
 	OCSPRespKey             string
 	FetchOCSPRespFromSecret bool
+	// ClusterDomain is the DNS domain of the cluster.  It is used to construct the default SNI host name of backend TLS connection.
+	ClusterDomain string
+	// BackendCANamespaces is the comma separated list of namespaces whose Ingresses may reference backend CA certificate.  If it is
+	// empty, all namespaces are allowed.
+	BackendCANamespaces string
+	// ShareTLSTicketKey, if true, shares TLS session ticket keys among controllers.  The leader generates and rotates keys, and stores
+	// them in Secret.
+	ShareTLSTicketKey bool
+	// TLSTicketKeyPeriod is the duration before TLS session ticket key is rotated.
+	TLSTicketKeyPeriod time.Duration
+	// StrictNghttpxConf, if true, allows only known safe options in nghttpx-conf in ConfigMap.
+	StrictNghttpxConf bool
+	// OutlierDetection is the configuration of passive outlier detection.  If it is nil, outlier detection is disabled.
+	OutlierDetection *OutlierDetectionConfig
+	// Topology is the configuration of zone-aware routing.  If it is nil, zone-aware routing is disabled.
+	Topology *TopologyConfig
+	// DrainPeriod is the duration to wait after this address is removed from Ingress status before nghttpx is stopped, so that
+	// external load balancers stop sending new requests.
+	DrainPeriod time.Duration
+	// NghttpxQuitTimeout is the maximum duration to wait for nghttpx to exit gracefully.  nghttpx is killed after this duration.  If
+	// it is zero, the controller waits for nghttpx indefinitely.
+	NghttpxQuitTimeout time.Duration
+	// ReadinessMaxSyncAge is the maximum duration since the last successful sync while sync keeps failing before the controller
+	// becomes not ready.
+	ReadinessMaxSyncAge time.Duration
+	// IngressStatusAnnotation, if true, makes the leader write whether each Ingress is programmed into nghttpx to its annotation.
+	IngressStatusAnnotation bool
+	// SyncDebounceWindow is the duration that sync waits for further events after an event comes.  If it is zero, sync is not
+	// debounced.
+	SyncDebounceWindow time.Duration
+	// SyncMaxDelay is the maximum duration that sync is delayed by debouncing since the first event.
+	SyncMaxDelay time.Duration
+	// ReloadMinInterval is the minimum interval between reloads of nghttpx main configuration.  If it is zero, reload is not limited.
+	ReloadMinInterval time.Duration
+	// BackendUpdateMinInterval is the minimum interval between updates of nghttpx backend configuration through API.  If it is zero,
+	// update is not limited.
+	BackendUpdateMinInterval time.Duration
+	// SyncRetryBaseDelay is the delay before the first retry of failed sync.  The delay doubles on each retry.
+	SyncRetryBaseDelay time.Duration
+	// SyncRetryMaxDelay is the maximum delay between retries of failed sync.
+	SyncRetryMaxDelay time.Duration
+	// SyncMaxRetries is the maximum number of consecutive retries of failed sync.  After that, sync is not retried until the next
+	// change of resources.  If it is zero, failed sync is retried indefinitely.
+	SyncMaxRetries int
 }
 
 // NewLoadBalancerController creates a controller for nghttpx loadbalancer
 func NewLoadBalancerController(clientset clientset.Interface, manager nghttpx.Interface, config *Config, runtimeInfo *PodInfo) *LoadBalancerController {
+	watchNamespaces := splitNamespaces(config.WatchNamespace)
+
+	// If just one namespace is watched, Ingress informer only watches it.  Otherwise, it watches all namespaces, and Ingresses are
+	// filtered by namespaceWatched.
+	ingNamespace := metav1.NamespaceAll
+	if len(watchNamespaces) == 1 && config.WatchNamespaceSelector == nil {
+		for ns := range watchNamespaces {
+			ingNamespace = ns
+		}
+	}
+
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.Infof)
-	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(clientset.CoreV1().RESTClient()).Events(config.WatchNamespace)})
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(clientset.CoreV1().RESTClient()).Events(ingNamespace)})
 
 	lbc := LoadBalancerController{
-		clientset:               clientset,
-		stopCh:                  make(chan struct{}),
-		podInfo:                 runtimeInfo,
-		nghttpx:                 manager,
-		ngxConfigMap:            config.NghttpxConfigMap,
-		nghttpxHealthPort:       config.NghttpxHealthPort,
-		nghttpxAPIPort:          config.NghttpxAPIPort,
-		nghttpxConfDir:          config.NghttpxConfDir,
-		nghttpxExecPath:         config.NghttpxExecPath,
-		nghttpxHTTPPort:         config.NghttpxHTTPPort,
-		nghttpxHTTPSPort:        config.NghttpxHTTPSPort,
-		defaultSvc:              config.DefaultBackendService,
-		defaultTLSSecret:        config.DefaultTLSSecret,
-		watchNamespace:          config.WatchNamespace,
-		ingressClass:            config.IngressClass,
-		allowInternalIP:         config.AllowInternalIP,
-		ocspRespKey:             config.OCSPRespKey,
-		fetchOCSPRespFromSecret: config.FetchOCSPRespFromSecret,
-		recorder:                eventBroadcaster.NewRecorder(api.Scheme, clientv1.EventSource{Component: "nghttpx-ingress-controller"}),
-		syncQueue:               workqueue.New(),
-		reloadRateLimiter:       flowcontrol.NewTokenBucketRateLimiter(1.0, 1),
+		clientset:                clientset,
+		shutdownCh:               make(chan struct{}),
+		stopCh:                   make(chan struct{}),
+		drainPeriod:              config.DrainPeriod,
+		nghttpxQuitTimeout:       config.NghttpxQuitTimeout,
+		readinessMaxSyncAge:      config.ReadinessMaxSyncAge,
+		ingStatusAnnotation:      config.IngressStatusAnnotation,
+		ingStatusRateLimiter:     flowcontrol.NewTokenBucketRateLimiter(5.0, 10),
+		podInfo:                  runtimeInfo,
+		nghttpx:                  manager,
+		ngxConfigMap:             config.NghttpxConfigMap,
+		nghttpxHealthPort:        config.NghttpxHealthPort,
+		nghttpxAPIPort:           config.NghttpxAPIPort,
+		nghttpxConfDir:           config.NghttpxConfDir,
+		nghttpxExecPath:          config.NghttpxExecPath,
+		frontends:                config.NghttpxFrontends,
+		listenTLSWithoutTLS:      config.ListenTLSFrontendWithoutTLS,
+		defaultSvc:               config.DefaultBackendService,
+		defaultTLSSecret:         config.DefaultTLSSecret,
+		watchNamespaces:          watchNamespaces,
+		watchNamespaceSelector:   config.WatchNamespaceSelector,
+		ingressClass:             config.IngressClass,
+		allowInternalIP:          config.AllowInternalIP,
+		ocspRespKey:              config.OCSPRespKey,
+		fetchOCSPRespFromSecret:  config.FetchOCSPRespFromSecret,
+		clusterDomain:            config.ClusterDomain,
+		backendCANamespaces:      splitNamespaces(config.BackendCANamespaces),
+		cmEvents:                 make(map[string]configMapEvent),
+		shareTLSTicketKey:        config.ShareTLSTicketKey,
+		tlsTicketKeyPeriod:       config.TLSTicketKeyPeriod,
+		strictNghttpxConf:        config.StrictNghttpxConf,
+		recorder:                 eventBroadcaster.NewRecorder(scheme.Scheme, clientv1.EventSource{Component: "nghttpx-ingress-controller"}),
+		syncMaxRetries:           config.SyncMaxRetries,
+		topology:                 config.Topology,
+		reloadMinInterval:        config.ReloadMinInterval,
+		backendUpdateMinInterval: config.BackendUpdateMinInterval,
+	}
+
+	retryBaseDelay := config.SyncRetryBaseDelay
+	if retryBaseDelay <= 0 {
+		retryBaseDelay = defaultSyncRetryBaseDelay
+	}
+	retryMaxDelay := config.SyncRetryMaxDelay
+	if retryMaxDelay <= 0 {
+		retryMaxDelay = defaultSyncRetryMaxDelay
+	}
+	lbc.syncQueue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay))
+
+	if config.SyncDebounceWindow > 0 {
+		lbc.syncDebouncer = newSyncDebouncer(config.SyncDebounceWindow, config.SyncMaxDelay)
+	}
+
+	if len(lbc.frontends) == 0 {
+		lbc.frontends = []nghttpx.Frontend{
+			{Host: "*", Port: config.NghttpxHTTPPort},
+			{Host: "*", Port: config.NghttpxHTTPSPort, TLS: true},
+		}
 	}
 
+	lbc.healthChecker = newHealthChecker(lbc.stopCh, func() { lbc.enqueue(syncKey) })
+
+	if config.OutlierDetection != nil {
+		lbc.outlierDetector = newOutlierDetector(*config.OutlierDetection, lbc.recorder, func() { lbc.enqueue(syncKey) })
+	}
+
+	lbc.leaderElector = newLeaderElector(clientset, runtimeInfo.PodNamespace,
+		fmt.Sprintf("nghttpx-ingress-controller-leader-%v", config.IngressClass), runtimeInfo.PodName)
+
 	{
 		indexer, controller := cache.NewIndexerInformer(
 			&cache.ListWatch{
 				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-					return lbc.clientset.ExtensionsV1beta1().Ingresses(config.WatchNamespace).List(options)
+					return lbc.clientset.ExtensionsV1beta1().Ingresses(ingNamespace).List(options)
 				},
 				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-					return lbc.clientset.ExtensionsV1beta1().Ingresses(config.WatchNamespace).Watch(options)
+					return lbc.clientset.ExtensionsV1beta1().Ingresses(ingNamespace).Watch(options)
 				},
 			},
 			&extensions.Ingress{},
@@ -239,7 +449,11 @@ func NewLoadBalancerController(clientset clientset.Interface, manager nghttpx.In
 			},
 			&v1.Service{},
 			depResyncPeriod(),
-			cache.ResourceEventHandlerFuncs{},
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    lbc.addServiceNotification,
+				UpdateFunc: lbc.updateServiceNotification,
+				DeleteFunc: lbc.deleteServiceNotification,
+			},
 			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 		)
 
@@ -307,7 +521,10 @@ func NewLoadBalancerController(clientset clientset.Interface, manager nghttpx.In
 			},
 			&v1.Node{},
 			depResyncPeriod(),
-			cache.ResourceEventHandlerFuncs{},
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    lbc.addNodeNotification,
+				UpdateFunc: lbc.updateNodeNotification,
+			},
 			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 		)
 
@@ -315,6 +532,30 @@ func NewLoadBalancerController(clientset clientset.Interface, manager nghttpx.In
 		lbc.nodeController = controller
 	}
 
+	if lbc.watchNamespaceSelector != nil {
+		indexer, controller := cache.NewIndexerInformer(
+			&cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return lbc.clientset.CoreV1().Namespaces().List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return lbc.clientset.CoreV1().Namespaces().Watch(options)
+				},
+			},
+			&v1.Namespace{},
+			depResyncPeriod(),
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    lbc.addNamespaceNotification,
+				UpdateFunc: lbc.updateNamespaceNotification,
+				DeleteFunc: lbc.deleteNamespaceNotification,
+			},
+			cache.Indexers{},
+		)
+
+		lbc.nsLister = newNamespaceLister(indexer)
+		lbc.nsController = controller
+	}
+
 	var cmNamespace string
 	if lbc.ngxConfigMap != "" {
 		ns, _, _ := cache.SplitMetaNamespaceKey(lbc.ngxConfigMap)
@@ -355,7 +596,7 @@ func NewLoadBalancerController(clientset clientset.Interface, manager nghttpx.In
 
 func (lbc *LoadBalancerController) addIngressNotification(obj interface{}) {
 	ing := obj.(*extensions.Ingress)
-	if !lbc.validateIngressClass(ing) {
+	if !lbc.ingressManaged(ing) {
 		return
 	}
 	glog.V(4).Infof("Ingress %v/%v added", ing.Namespace, ing.Name)
@@ -365,7 +606,7 @@ func (lbc *LoadBalancerController) addIngressNotification(obj interface{}) {
 func (lbc *LoadBalancerController) updateIngressNotification(old interface{}, cur interface{}) {
 	oldIng := old.(*extensions.Ingress)
 	curIng := cur.(*extensions.Ingress)
-	if !lbc.validateIngressClass(oldIng) && !lbc.validateIngressClass(curIng) {
+	if !lbc.ingressManaged(oldIng) && !lbc.ingressManaged(curIng) {
 		return
 	}
 	glog.V(4).Infof("Ingress %v/%v updated", curIng.Namespace, curIng.Name)
@@ -386,13 +627,54 @@ func (lbc *LoadBalancerController) deleteIngressNotification(obj interface{}) {
 			return
 		}
 	}
-	if !lbc.validateIngressClass(ing) {
+	if !lbc.ingressManaged(ing) {
 		return
 	}
 	glog.V(4).Infof("Ingress %v/%v deleted", ing.Namespace, ing.Name)
 	lbc.enqueue(syncKey)
 }
 
+func (lbc *LoadBalancerController) addNamespaceNotification(obj interface{}) {
+	ns := obj.(*v1.Namespace)
+	if !lbc.watchNamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		return
+	}
+	glog.V(4).Infof("Namespace %v added", ns.Name)
+	lbc.enqueue(syncKey)
+}
+
+func (lbc *LoadBalancerController) updateNamespaceNotification(old, cur interface{}) {
+	oldNs := old.(*v1.Namespace)
+	curNs := cur.(*v1.Namespace)
+	// We are only interested in the change of selection.
+	if lbc.watchNamespaceSelector.Matches(labels.Set(oldNs.Labels)) == lbc.watchNamespaceSelector.Matches(labels.Set(curNs.Labels)) {
+		return
+	}
+	glog.V(4).Infof("Namespace %v updated", curNs.Name)
+	lbc.enqueue(syncKey)
+}
+
+func (lbc *LoadBalancerController) deleteNamespaceNotification(obj interface{}) {
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			glog.Errorf("Couldn't get object from tombstone %+v", obj)
+			return
+		}
+		ns, ok = tombstone.Obj.(*v1.Namespace)
+		if !ok {
+			glog.Errorf("Tombstone contained object that is not Namespace %+v", obj)
+			return
+		}
+	}
+	if !lbc.watchNamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		return
+	}
+	glog.V(4).Infof("Namespace %v deleted", ns.Name)
+	lbc.enqueue(syncKey)
+}
+
 func (lbc *LoadBalancerController) addEndpointsNotification(obj interface{}) {
 	ep := obj.(*v1.Endpoints)
 	if !lbc.endpointsReferenced(ep) {
@@ -437,23 +719,47 @@ func (lbc *LoadBalancerController) deleteEndpointsNotification(obj interface{})
 	lbc.enqueue(syncKey)
 }
 
-// endpointsReferenced returns true if we are interested in ep.
+// endpointsReferenced returns true if we are interested in ep.  Endpoints are not interesting if Ingresses route requests to ClusterIP of
+// the Service.
 func (lbc *LoadBalancerController) endpointsReferenced(ep *v1.Endpoints) bool {
-	if fmt.Sprintf("%v/%v", ep.Namespace, ep.Name) == lbc.defaultSvc {
+	ignoreClusterIP := false
+	if svc, err := lbc.svcLister.Services(ep.Namespace).Get(ep.Name); err == nil {
+		ignoreClusterIP = serviceHasClusterIP(svc)
+	}
+	return lbc.serviceReferenced(ep.Namespace, ep.Name, ignoreClusterIP)
+}
+
+// serviceReferenced returns true if Service denoted by namespace and name is the default backend, or referenced by Ingress.  If
+// ignoreClusterIP is true, references which route requests to ClusterIP of the Service are ignored.
+func (lbc *LoadBalancerController) serviceReferenced(namespace, name string, ignoreClusterIP bool) bool {
+	if fmt.Sprintf("%v/%v", namespace, name) == lbc.defaultSvc {
 		return true
 	}
 
-	ings, err := lbc.ingLister.Ingresses(ep.Namespace).List(labels.Everything())
+	// referenced returns true if backend refers to the Service.
+	referenced := func(backend *extensions.IngressBackend, backendConfig map[string]map[string]nghttpx.PortBackendConfig) bool {
+		if backend.ServiceName != name {
+			return false
+		}
+		if !ignoreClusterIP {
+			return true
+		}
+		portBackendConfig, ok := backendConfig[backend.ServiceName][backend.ServicePort.String()]
+		return !ok || !portBackendConfig.ClusterIP
+	}
+
+	ings, err := lbc.ingLister.Ingresses(namespace).List(labels.Everything())
 	if err != nil {
-		glog.Errorf("Could not list Ingress namespace=%v: %v", ep.Namespace, err)
+		glog.Errorf("Could not list Ingress namespace=%v: %v", namespace, err)
 		return false
 	}
 	for _, ing := range ings {
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
-		if ing.Spec.Backend != nil && ep.Name == ing.Spec.Backend.ServiceName {
-			glog.V(4).Infof("Endpoints %v/%v is referenced by Ingress %v/%v", ep.Namespace, ep.Name, ing.Namespace, ing.Name)
+		backendConfig := ingressAnnotation(ing.ObjectMeta.Annotations).getBackendConfig()
+		if ing.Spec.Backend != nil && referenced(ing.Spec.Backend, backendConfig) {
+			glog.V(4).Infof("Service %v/%v is referenced by Ingress %v/%v", namespace, name, ing.Namespace, ing.Name)
 			return true
 		}
 		for i, _ := range ing.Spec.Rules {
@@ -463,8 +769,8 @@ func (lbc *LoadBalancerController) endpointsReferenced(ep *v1.Endpoints) bool {
 			}
 			for i, _ := range rule.HTTP.Paths {
 				path := &rule.HTTP.Paths[i]
-				if ep.Name == path.Backend.ServiceName {
-					glog.V(4).Infof("Endpoints %v/%v is referenced by Ingress %v/%v", ep.Namespace, ep.Name, ing.Namespace, ing.Name)
+				if referenced(&path.Backend, backendConfig) {
+					glog.V(4).Infof("Service %v/%v is referenced by Ingress %v/%v", namespace, name, ing.Namespace, ing.Name)
 					return true
 				}
 			}
@@ -473,6 +779,54 @@ func (lbc *LoadBalancerController) endpointsReferenced(ep *v1.Endpoints) bool {
 	return false
 }
 
+// addServiceNotification handles the addition of Service.  Service is interesting here because the backend of ExternalName Service, and
+// Service which is routed to its ClusterIP is determined by Service itself, rather than Endpoints.  Service port also determines which
+// port of endpoints is used.  The change of health check configuration is also interesting on update.
+func (lbc *LoadBalancerController) addServiceNotification(obj interface{}) {
+	svc := obj.(*v1.Service)
+	if !lbc.serviceReferenced(svc.Namespace, svc.Name, false) {
+		return
+	}
+	glog.V(4).Infof("Service %v/%v added", svc.Namespace, svc.Name)
+	lbc.enqueue(syncKey)
+}
+
+func (lbc *LoadBalancerController) updateServiceNotification(old, cur interface{}) {
+	oldSvc := old.(*v1.Service)
+	curSvc := cur.(*v1.Service)
+	specChanged := !reflect.DeepEqual(oldSvc.Spec, curSvc.Spec)
+	healthCheckChanged := oldSvc.Annotations[healthCheckKey] != curSvc.Annotations[healthCheckKey]
+	if !specChanged && !healthCheckChanged {
+		return
+	}
+	if !lbc.serviceReferenced(curSvc.Namespace, curSvc.Name, false) {
+		return
+	}
+	glog.V(4).Infof("Service %v/%v updated", curSvc.Namespace, curSvc.Name)
+	lbc.enqueue(syncKey)
+}
+
+func (lbc *LoadBalancerController) deleteServiceNotification(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			glog.Errorf("Couldn't get object from tombstone %+v", obj)
+			return
+		}
+		svc, ok = tombstone.Obj.(*v1.Service)
+		if !ok {
+			glog.Errorf("Tombstone contained object that is not Service %+v", obj)
+			return
+		}
+	}
+	if !lbc.serviceReferenced(svc.Namespace, svc.Name, false) {
+		return
+	}
+	glog.V(4).Infof("Service %v/%v deleted", svc.Namespace, svc.Name)
+	lbc.enqueue(syncKey)
+}
+
 func (lbc *LoadBalancerController) addSecretNotification(obj interface{}) {
 	s := obj.(*v1.Secret)
 	if !lbc.secretReferenced(s.Namespace, s.Name) {
@@ -626,7 +980,7 @@ func (lbc *LoadBalancerController) podReferenced(pod *v1.Pod) bool {
 		return false
 	}
 	for _, ing := range ings {
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
 		if ing.Spec.Backend != nil {
@@ -661,8 +1015,47 @@ func (lbc *LoadBalancerController) podReferenced(pod *v1.Pod) bool {
 	return false
 }
 
+// addNodeNotification handles the addition of Node.  The zone of Node is used by zone-aware routing.  Endpoints on Node which was not
+// in the lister had unknown zone.
+func (lbc *LoadBalancerController) addNodeNotification(obj interface{}) {
+	if lbc.topology == nil {
+		return
+	}
+	node := obj.(*v1.Node)
+	if nodeZone(node) == "" {
+		return
+	}
+	glog.V(4).Infof("Node %v added", node.Name)
+	lbc.enqueue(syncKey)
+}
+
+// updateNodeNotification handles the update of Node.  Only the change of zone is interesting.
+func (lbc *LoadBalancerController) updateNodeNotification(old, cur interface{}) {
+	if lbc.topology == nil {
+		return
+	}
+	oldNode := old.(*v1.Node)
+	curNode := cur.(*v1.Node)
+	if nodeZone(oldNode) == nodeZone(curNode) {
+		return
+	}
+	glog.V(4).Infof("Zone of Node %v changed from %q to %q", curNode.Name, nodeZone(oldNode), nodeZone(curNode))
+	lbc.enqueue(syncKey)
+}
+
 func (lbc *LoadBalancerController) enqueue(key string) {
-	lbc.syncQueue.Add(key)
+	syncEvents.Add(1)
+
+	if lbc.syncDebouncer == nil {
+		lbc.syncQueue.Add(key)
+		return
+	}
+
+	if lbc.syncDebouncer.add(time.Now()) {
+		syncEventsCoalesced.Add(1)
+	}
+
+	lbc.syncQueue.AddAfter(key, lbc.syncDebouncer.window)
 }
 
 func (lbc *LoadBalancerController) worker() {
@@ -673,7 +1066,16 @@ func (lbc *LoadBalancerController) worker() {
 		}
 
 		defer lbc.syncQueue.Done(key)
-		if err := lbc.sync(key.(string)); err != nil {
+
+		if lbc.syncDebouncer != nil {
+			// More events might have come since key was queued.
+			if delay := lbc.syncDebouncer.take(time.Now()); delay > 0 {
+				lbc.syncQueue.AddAfter(key, delay)
+				return false
+			}
+		}
+
+		if err := lbc.sync(key.(string)); err != nil && err != errConfigChangeDeferred {
 			glog.Error(err)
 		}
 
@@ -694,7 +1096,8 @@ func (lbc *LoadBalancerController) controllersInSync() bool {
 		lbc.secretController.HasSynced() &&
 		lbc.cmController.HasSynced() &&
 		lbc.podController.HasSynced() &&
-		lbc.nodeController.HasSynced()
+		lbc.nodeController.HasSynced() &&
+		(lbc.nsController == nil || lbc.nsController.HasSynced())
 }
 
 // getConfigMap returns ConfigMap denoted by cmKey.
@@ -715,18 +1118,78 @@ func (lbc *LoadBalancerController) getConfigMap(cmKey string) (*v1.ConfigMap, er
 	return cm, nil
 }
 
-func (lbc *LoadBalancerController) sync(key string) error {
-	lbc.reloadRateLimiter.Accept()
+// configMapEvent is the Event last recorded on the nghttpx ConfigMap for a reason.
+type configMapEvent struct {
+	// data is the content of ConfigMap when the Event was recorded.
+	data map[string]string
+	// message is the message of the Event.
+	message string
+}
+
+// recordConfigMapEvent records a Warning Event with reason on cm if err is not nil.  The Event is recorded only if cm exists, and its
+// content or err has changed since the last Event with the same reason.
+func (lbc *LoadBalancerController) recordConfigMapEvent(cm *v1.ConfigMap, reason, msg string, err error) {
+	if err == nil || cm.Name == "" {
+		delete(lbc.cmEvents, reason)
+		return
+	}
+
+	ev := configMapEvent{
+		data:    cm.Data,
+		message: fmt.Sprintf("%v: %v", msg, err),
+	}
+	if last, ok := lbc.cmEvents[reason]; ok && last.message == ev.message && reflect.DeepEqual(last.data, ev.data) {
+		return
+	}
+
+	lbc.recorder.Event(cm, v1.EventTypeWarning, reason, ev.message)
+	lbc.cmEvents[reason] = ev
+}
 
-	retry := false
+// disableUnsupportedFeatures logs the features which are not configured by ConfigMap, and are disabled because nghttpx described by caps
+// does not support them.  PROXY protocol is removed from the frontends given by command-line flags here, so that it is not reported as
+// a problem of ConfigMap.  It must be called before the worker starts.
+func (lbc *LoadBalancerController) disableUnsupportedFeatures(caps *nghttpx.Capabilities) {
+	if lbc.outlierDetector != nil && !caps.BackendLogVariables {
+		glog.Warningf("Outlier detection is disabled because nghttpx %v does not support $backend_host and $backend_port in access log "+
+			"format", caps.Version)
+	}
+	if !caps.ProxyProto {
+		frontends := make([]nghttpx.Frontend, len(lbc.frontends))
+		for i, fe := range lbc.frontends {
+			if fe.ProxyProto {
+				glog.Warningf("PROXY protocol is disabled for frontend %v,%v because nghttpx %v does not support it", fe.Host, fe.Port,
+					caps.Version)
+				fe.ProxyProto = false
+			}
+			frontends[i] = fe
+		}
+		lbc.frontends = frontends
+	}
+	if !caps.CookieAffinity {
+		glog.Warningf("nghttpx %v does not support cookie based session affinity; ip affinity is used instead", caps.Version)
+	}
+	if !caps.PerPatternMruby {
+		glog.Warningf("nghttpx %v does not support per-pattern mruby script; the hosts which are not bound to any certificate are "+
+			"accessible via TLS", caps.Version)
+	}
+}
 
-	defer func() { lbc.retryOrForget(key, retry) }()
+func (lbc *LoadBalancerController) sync(key string) (err error) {
+	defer func() {
+		if err == errConfigChangeDeferred {
+			// key has been requeued with delay.  Nothing has been applied, and it is not a failure either.
+			return
+		}
+		lbc.recordSyncResult(err)
+		lbc.retryOrForget(key, err != nil)
+	}()
 
 	ings, err := lbc.ingLister.List(labels.Everything())
 	if err != nil {
 		return err
 	}
-	ingConfig, err := lbc.getUpstreamServers(ings)
+	ingConfig, ingResults, err := lbc.getUpstreamServers(ings)
 	if err != nil {
 		return err
 	}
@@ -736,12 +1199,98 @@ func (lbc *LoadBalancerController) sync(key string) error {
 		return err
 	}
 
-	nghttpx.ReadConfig(ingConfig, cm)
+	if ingConfig.ClientCACert, err = lbc.getClientCACert(ingConfig, cm); err != nil {
+		return err
+	}
+
+	err = nghttpx.ReadConfig(ingConfig, cm)
+	if err != nil {
+		glog.Warningf("ConfigMap %v contains invalid values, and they are ignored: %v", lbc.ngxConfigMap, err)
+	}
+	lbc.recordConfigMapEvent(cm, "InvalidConfig", "Invalid values are ignored", err)
+
+	err = nghttpx.RemoveUnsupportedOptions(ingConfig, lbc.nghttpx.Capabilities())
+	if err != nil {
+		glog.Warningf("nghttpx does not support some settings in ConfigMap %v, and they are ignored: %v", lbc.ngxConfigMap, err)
+	}
+	lbc.recordConfigMapEvent(cm, "UnsupportedConfig", "Unsupported settings are ignored", err)
+
+	change := nghttpx.ConfigNotChanged
+	if lbc.reloadMinInterval > 0 || lbc.backendUpdateMinInterval > 0 {
+		if change, err = lbc.nghttpx.DetectConfigChange(ingConfig); err != nil {
+			return err
+		}
+		if delay := lbc.configChangeDelay(change, time.Now()); delay > 0 {
+			glog.V(3).Infof("Defer %v configuration update for %v", change, delay)
+			syncDeferredUpdates.Add(change.String(), 1)
+			lbc.syncQueue.AddAfter(key, delay)
+			return errConfigChangeDeferred
+		}
+	}
 
 	if reloaded, err := lbc.nghttpx.CheckAndReload(ingConfig); err != nil {
 		return err
 	} else if !reloaded {
 		glog.V(4).Infof("No need to reload configuration.")
+	} else {
+		lbc.recordConfigChange(change, time.Now())
+	}
+
+	lbc.syncStatusMu.Lock()
+	lbc.ingConfig = ingConfig
+	lbc.syncStatusMu.Unlock()
+
+	lbc.recordIngressStatuses(ingResults, ingConfig.Upstreams)
+
+	return nil
+}
+
+// recordSyncResult records the result of sync for readiness check.
+func (lbc *LoadBalancerController) recordSyncResult(err error) {
+	lbc.syncStatusMu.Lock()
+	defer lbc.syncStatusMu.Unlock()
+
+	lbc.lastSyncErr = err
+	if err == nil {
+		lbc.lastSyncTime = time.Now()
+	}
+}
+
+// IngressConfig returns the configuration which was applied to nghttpx by the last successful sync.  It returns nil if sync has never
+// succeeded.  The returned object must not be modified.
+func (lbc *LoadBalancerController) IngressConfig() *nghttpx.IngressConfig {
+	lbc.syncStatusMu.Lock()
+	defer lbc.syncStatusMu.Unlock()
+
+	return lbc.ingConfig
+}
+
+// Ready returns nil if the controller is ready to serve requests.  Otherwise it returns an error which describes the failing condition.
+// The controller is ready if it is not shutting down, all resource controllers have synced, and the configuration generated from
+// resources has been applied to nghttpx.  If the last sync failed, the last successful sync must be within readinessMaxSyncAge.
+func (lbc *LoadBalancerController) Ready() error {
+	if lbc.ShuttingDown() {
+		return fmt.Errorf("shutting down")
+	}
+
+	if !lbc.controllersInSyncHandler() {
+		return fmt.Errorf("resource controllers have not synced yet")
+	}
+
+	lbc.syncStatusMu.Lock()
+	defer lbc.syncStatusMu.Unlock()
+
+	if lbc.lastSyncTime.IsZero() {
+		if lbc.lastSyncErr != nil {
+			return fmt.Errorf("configuration has not been applied yet: %v", lbc.lastSyncErr)
+		}
+		return fmt.Errorf("configuration has not been applied yet")
+	}
+
+	if lbc.lastSyncErr != nil && lbc.readinessMaxSyncAge > 0 {
+		if age := time.Since(lbc.lastSyncTime); age > lbc.readinessMaxSyncAge {
+			return fmt.Errorf("last successful sync was %v ago: %v", age, lbc.lastSyncErr)
+		}
 	}
 
 	return nil
@@ -770,6 +1319,9 @@ func (lbc *LoadBalancerController) getDefaultUpstream() *nghttpx.Upstream {
 	portBackendConfig := nghttpx.DefaultPortBackendConfig()
 
 	eps := lbc.getEndpoints(svc, &svc.Spec.Ports[0], v1.ProtocolTCP, &portBackendConfig)
+	if lbc.topology != nil {
+		eps = lbc.preferLocalZone(svcKey, eps, eps)
+	}
 	if len(eps) == 0 {
 		glog.Warningf("service %v does no have any active endpoints", svcKey)
 		upstream.Backends = append(upstream.Backends, nghttpx.NewDefaultServer())
@@ -780,55 +1332,99 @@ func (lbc *LoadBalancerController) getDefaultUpstream() *nghttpx.Upstream {
 	return upstream
 }
 
-// in nghttpx terminology, nghttpx.Upstream is backend, nghttpx.Server is frontend
-func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress) (*nghttpx.IngressConfig, error) {
+// in nghttpx terminology, nghttpx.Upstream is backend, nghttpx.Server is frontend.  It also returns the result of processing each managed
+// Ingress keyed by namespace/name.
+func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress) (*nghttpx.IngressConfig,
+	map[string]*ingressProgramResult, error) {
 	ingConfig := nghttpx.NewIngressConfig()
 	ingConfig.HealthPort = lbc.nghttpxHealthPort
 	ingConfig.APIPort = lbc.nghttpxAPIPort
 	ingConfig.ConfDir = lbc.nghttpxConfDir
-	ingConfig.HTTPPort = lbc.nghttpxHTTPPort
-	ingConfig.HTTPSPort = lbc.nghttpxHTTPSPort
+	ingConfig.Frontends = lbc.frontends
+	ingConfig.ListenTLSFrontendWithoutTLS = lbc.listenTLSWithoutTLS
 	ingConfig.FetchOCSPRespFromSecret = lbc.fetchOCSPRespFromSecret
+	ingConfig.StrictExtraConfig = lbc.strictNghttpxConf
+	ingConfig.OutlierDetection = lbc.outlierDetector != nil
+	ingConfig.ShareTLSTicketKey = lbc.shareTLSTicketKey
 
 	var (
 		upstreams []*nghttpx.Upstream
 		pems      []*nghttpx.TLSCred
+		// backendCACerts is the CA certificates to verify backend server certificate keyed by Secret key.
+		backendCACerts = make(map[string][]byte)
+		// backendClientCred is the client certificate presented to backend server.
+		backendClientCred *nghttpx.TLSCred
 	)
 
 	if lbc.defaultTLSSecret != "" {
 		tlsCred, err := lbc.getTLSCredFromSecret(lbc.defaultTLSSecret)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		ingConfig.TLS = true
 		ingConfig.DefaultTLSCred = tlsCred
 	}
 
-	var defaultUpstream *nghttpx.Upstream
+	// The oldest Ingress wins when several Ingresses define the same host and path, or default backend.
+	sortIngressesByCreationTimestamp(ings)
+
+	var (
+		defaultUpstream *nghttpx.Upstream
+		// defaultUpstreamOwner is the Ingress which defines defaultUpstream.
+		defaultUpstreamOwner *extensions.Ingress
+		// hostPathOwners maps host and path to the Ingress which serves it.
+		hostPathOwners = make(map[string]*extensions.Ingress)
+		// results is the result of processing each managed Ingress keyed by namespace/name.
+		results = make(map[string]*ingressProgramResult)
+	)
 
 	for _, ing := range ings {
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
-		var requireTLS bool
-		if ingPems, err := lbc.getTLSCredFromIngress(ing); err != nil {
+
+		res := &ingressProgramResult{}
+		results[fmt.Sprintf("%v/%v", ing.Namespace, ing.Name)] = res
+
+		ingPems, err := lbc.getTLSCredFromIngress(ing)
+		if err != nil {
 			glog.Warningf("Ingress %v/%v is disabled because its TLS Secret cannot be processed: %v", ing.Namespace, ing.Name, err)
+			res.reject("TLS Secret cannot be processed: %v", err)
 			continue
-		} else {
-			pems = append(pems, ingPems...)
-			requireTLS = len(ingPems) > 0
 		}
 
 		backendConfig := ingressAnnotation(ing.ObjectMeta.Annotations).getBackendConfig()
+		allowHTTP := ingressAnnotation(ing.ObjectMeta.Annotations).getAllowHTTP()
+
+		ingCACerts, ingClientCred, err := lbc.getBackendTLSFromIngress(ing, backendConfig, backendClientCred)
+		if err != nil {
+			glog.Warningf("Ingress %v/%v is disabled because its backend TLS Secret cannot be processed: %v", ing.Namespace, ing.Name, err)
+			res.reject("backend TLS Secret cannot be processed: %v", err)
+			continue
+		}
+
+		pems = append(pems, ingPems...)
+		for k, v := range ingCACerts {
+			backendCACerts[k] = v
+		}
+		backendClientCred = ingClientCred
 
 		if ing.Spec.Backend != nil {
 			// This overrides the default backend specified in command-line.  It is possible that the multiple Ingress resource
-			// specifies this.  But specification does not any rules how to deal with it.  Just use the one we meet last.
-			if ups, err := lbc.createUpstream(ing, "", "/", ing.Spec.Backend, false, backendConfig); err != nil {
+			// specifies this.  But specification does not any rules how to deal with it.  Use the one which is created first.
+			if defaultUpstreamOwner != nil {
+				glog.Warningf("Ignoring default backend of Ingress %v/%v because Ingress %v/%v defines it", ing.Namespace, ing.Name,
+					defaultUpstreamOwner.Namespace, defaultUpstreamOwner.Name)
+				res.conflict(defaultUpstreamOwner, "default backend conflicts with Ingress %v/%v", defaultUpstreamOwner.Namespace,
+					defaultUpstreamOwner.Name)
+			} else if ups, err := lbc.createUpstream(ing, "", "/", ing.Spec.Backend, false, backendConfig, res); err != nil {
 				glog.Errorf("Could not create default backend for Ingress %v/%v: %v", ing.Namespace, ing.Name, err)
+				res.fail("default backend: %v", err)
 			} else {
 				defaultUpstream = ups
+				defaultUpstreamOwner = ing
+				res.succeed()
 			}
 		}
 
@@ -841,16 +1437,42 @@ func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress
 
 			for i, _ := range rule.HTTP.Paths {
 				path := &rule.HTTP.Paths[i]
-				if ups, err := lbc.createUpstream(ing, rule.Host, path.Path, &path.Backend, requireTLS, backendConfig); err != nil {
+
+				hostPath := rule.Host + path.Path
+				if path.Path == "" {
+					hostPath += "/"
+				}
+				owner := hostPathOwners[hostPath]
+				if owner != nil && owner != ing {
+					glog.Warningf("Ignoring host %q and path %q of Ingress %v/%v because Ingress %v/%v defines them", rule.Host,
+						path.Path, ing.Namespace, ing.Name, owner.Namespace, owner.Name)
+					res.conflict(owner, "host %q and path %q conflict with Ingress %v/%v", rule.Host, path.Path, owner.Namespace,
+						owner.Name)
+					continue
+				}
+
+				// If the Ingress has TLS configuration, the hosts not listed in it are served via cleartext HTTP only if it is
+				// explicitly allowed.
+				requireTLS := len(ing.Spec.TLS) > 0 && (!allowHTTP || ingressTLSCoversHost(ing, rule.Host))
+				if ups, err := lbc.createUpstream(ing, rule.Host, path.Path, &path.Backend, requireTLS, backendConfig,
+					res); err != nil {
 					glog.Errorf("Could not create backend for Ingress %v/%v: %v", ing.Namespace, ing.Name, err)
+					res.fail("host %q and path %q: %v", rule.Host, path.Path, err)
 					continue
 				} else {
 					upstreams = append(upstreams, ups)
+					hostPathOwners[hostPath] = ing
+					res.succeed()
 				}
 			}
 		}
 	}
 
+	lbc.healthChecker.prune()
+	if lbc.outlierDetector != nil {
+		lbc.outlierDetector.prune()
+	}
+
 	sort.Slice(pems, func(i, j int) bool { return pems[i].Key.Path < pems[j].Key.Path })
 	pems = nghttpx.RemoveDuplicatePems(pems)
 
@@ -858,6 +1480,7 @@ func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress
 		// Remove default TLS key pair from pems.
 		for i, _ := range pems {
 			if ingConfig.DefaultTLSCred.Key.Path == pems[i].Key.Path {
+				ingConfig.DefaultTLSCred.Hosts = nghttpx.MergeHosts(ingConfig.DefaultTLSCred.Hosts, pems[i].Hosts)
 				pems = append(pems[:i], pems[i+1:]...)
 				break
 			}
@@ -869,6 +1492,39 @@ func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress
 		ingConfig.SubTLSCred = pems[1:]
 	}
 
+	if ingConfig.TLS && lbc.defaultTLSSecret == "" {
+		// Certificates are bound to the hosts listed in Ingress .spec.tls[*].hosts.  The hosts which are not bound to any certificate,
+		// and are not redirected to https URI, must not be served via TLS with a certificate of the other hosts.
+		tlsHosts := make(map[string]bool)
+		for _, h := range ingConfig.DefaultTLSCred.Hosts {
+			tlsHosts[h] = true
+		}
+		for _, tlsCred := range ingConfig.SubTLSCred {
+			for _, h := range tlsCred.Hosts {
+				tlsHosts[h] = true
+			}
+		}
+		for _, ups := range upstreams {
+			if ups.RedirectIfNotTLS {
+				tlsHosts[strings.ToLower(ups.Host)] = true
+			}
+		}
+		for _, ups := range upstreams {
+			if ups.Host != "" && !hostMatches(tlsHosts, ups.Host) {
+				ups.RejectTLS = true
+				ingConfig.RejectTLSMrubyFile = nghttpx.CreateRejectTLSMrubyFile(lbc.nghttpxConfDir)
+			}
+		}
+	}
+
+	if ingConfig.TLS && lbc.shareTLSTicketKey {
+		ingConfig.TLSTicketKeyFiles = lbc.getTLSTicketKeyFiles()
+	}
+
+	ingConfig.BackendCACert = createBackendCACert(lbc.nghttpxConfDir, backendCACerts)
+
+	ingConfig.BackendClientTLSCred = backendClientCred
+
 	// find default backend.  If only it is not found, use default backend.  This is useful to override default backend with ingress.
 	defaultUpstreamFound := false
 
@@ -911,12 +1567,27 @@ func (lbc *LoadBalancerController) getUpstreamServers(ings []*extensions.Ingress
 
 	ingConfig.Upstreams = upstreams
 
-	return ingConfig, nil
+	return ingConfig, results, nil
+}
+
+// sortIngressesByCreationTimestamp sorts ings in the ascending order of their creation timestamp.  Ingresses which are created at the same
+// time are sorted by namespace and name.
+func sortIngressesByCreationTimestamp(ings []*extensions.Ingress) {
+	sort.Slice(ings, func(i, j int) bool {
+		lhs, rhs := ings[i], ings[j]
+		if !lhs.CreationTimestamp.Equal(rhs.CreationTimestamp) {
+			return lhs.CreationTimestamp.Before(rhs.CreationTimestamp)
+		}
+		if lhs.Namespace != rhs.Namespace {
+			return lhs.Namespace < rhs.Namespace
+		}
+		return lhs.Name < rhs.Name
+	})
 }
 
-// createUpstream creates new nghttpx.Upstream for ing, host, path and backend.
+// createUpstream creates new nghttpx.Upstream for ing, host, path and backend.  The settings which are not honored are recorded to res.
 func (lbc *LoadBalancerController) createUpstream(ing *extensions.Ingress, host, path string, backend *extensions.IngressBackend,
-	requireTLS bool, backendConfig map[string]map[string]nghttpx.PortBackendConfig) (*nghttpx.Upstream, error) {
+	requireTLS bool, backendConfig map[string]map[string]nghttpx.PortBackendConfig, res *ingressProgramResult) (*nghttpx.Upstream, error) {
 	var normalizedPath string
 	if path == "" {
 		normalizedPath = "/"
@@ -932,6 +1603,7 @@ func (lbc *LoadBalancerController) createUpstream(ing *extensions.Ingress, host,
 		Host:             host,
 		Path:             normalizedPath,
 		RedirectIfNotTLS: requireTLS || lbc.defaultTLSSecret != "",
+		Source:           fmt.Sprintf("%v/%v", ing.Namespace, ing.Name),
 	}
 
 	glog.V(4).Infof("Found rule for upstream name=%v, host=%v, path=%v", upsName, ups.Host, ups.Path)
@@ -963,7 +1635,49 @@ func (lbc *LoadBalancerController) createUpstream(ing *extensions.Ingress, host,
 				portBackendConfig = nghttpx.DefaultPortBackendConfig()
 			}
 
-			eps := lbc.getEndpoints(svc, servicePort, v1.ProtocolTCP, &portBackendConfig)
+			if portBackendConfig.Affinity == nghttpx.AffinityCookie && !lbc.nghttpx.Capabilities().CookieAffinity {
+				glog.Warningf("nghttpx does not support cookie affinity; use ip affinity for service %v, port %v", svcKey, bp)
+				res.warn("nghttpx does not support cookie affinity; ip affinity is used for service %v, port %v instead", svcKey, bp)
+				portBackendConfig.Affinity = nghttpx.AffinityIP
+				portBackendConfig.AffinityCookieName = ""
+				portBackendConfig.AffinityCookiePath = ""
+				portBackendConfig.AffinityCookieSecure = ""
+			}
+
+			if portBackendConfig.TLS && portBackendConfig.SNI == "" {
+				if svc.Spec.Type == v1.ServiceTypeExternalName {
+					portBackendConfig.SNI = svc.Spec.ExternalName
+				} else {
+					portBackendConfig.SNI = fmt.Sprintf("%v.%v.svc.%v", svc.Name, svc.Namespace, lbc.clusterDomain)
+				}
+			}
+
+			var eps []nghttpx.UpstreamServer
+			switch {
+			case svc.Spec.Type == v1.ServiceTypeExternalName:
+				eps = getExternalNameBackend(svc, servicePort, &portBackendConfig)
+			case portBackendConfig.ClusterIP && serviceHasClusterIP(svc):
+				eps = getClusterIPBackend(svc, servicePort, &portBackendConfig)
+			default:
+				if portBackendConfig.ClusterIP {
+					glog.Warningf("Service %v has no ClusterIP; use its endpoints instead", svcKey)
+				}
+				eps = lbc.getEndpoints(svc, servicePort, v1.ProtocolTCP, &portBackendConfig)
+				allEps := eps
+				if s, ok := svc.Annotations[healthCheckKey]; ok {
+					if hcConfig, err := parseHealthCheckConfig(s); err != nil {
+						glog.Errorf("Could not parse %v annotation of Service %v: %v", healthCheckKey, svcKey, err)
+					} else {
+						eps = lbc.healthChecker.filter(fmt.Sprintf("%v,%v", svcKey, bp), hcConfig, eps)
+					}
+				}
+				if lbc.outlierDetector != nil {
+					eps = lbc.outlierDetector.filter(fmt.Sprintf("%v,%v", svcKey, bp), svc, eps)
+				}
+				if lbc.topology != nil {
+					eps = lbc.preferLocalZone(fmt.Sprintf("%v,%v", svcKey, bp), allEps, eps)
+				}
+			}
 			if len(eps) == 0 {
 				glog.Warningf("service %v does no have any active endpoints", svcKey)
 				break
@@ -1017,12 +1731,209 @@ func (lbc *LoadBalancerController) getTLSCredFromIngress(ing *extensions.Ingress
 			return nil, err
 		}
 
+		if len(tls.Hosts) == 0 {
+			// An item without hosts covers all hosts in the Ingress.
+			tlsCred.Hosts = nghttpx.MergeHosts(nil, ingressRuleHosts(ing))
+		} else {
+			tlsCred.Hosts = nghttpx.MergeHosts(nil, tls.Hosts)
+		}
+
+		if uncovered, err := nghttpx.UncoveredHosts(tlsCred.Cert.Content, tls.Hosts); err != nil {
+			glog.Warningf("Could not verify hosts of Ingress %v/%v against Secret %v: %v", ing.Namespace, ing.Name, secretKey, err)
+		} else if len(uncovered) > 0 {
+			glog.Warningf("TLS certificate in Secret %v does not cover hosts %q of Ingress %v/%v", secretKey, uncovered, ing.Namespace, ing.Name)
+			lbc.recorder.Eventf(ing, v1.EventTypeWarning, "TLSHostNotCovered", "TLS certificate in Secret %v does not cover hosts %q",
+				secretKey, uncovered)
+		}
+
 		pems = append(pems, tlsCred)
 	}
 
 	return pems, nil
 }
 
+// getBackendTLSFromIngress returns CA certificates and client certificate for backend TLS connections referenced by backendConfig of ing.
+// CA certificates are keyed by Secret key.  clientCred is the client certificate referenced by the Ingresses processed so far, and it
+// may be nil.  nghttpx presents only one client certificate to all backends, so that it returns an error if ing references a client
+// certificate other than clientCred.  Otherwise, it returns the client certificate shared by ing and the preceding Ingresses.
+func (lbc *LoadBalancerController) getBackendTLSFromIngress(ing *extensions.Ingress,
+	backendConfig map[string]map[string]nghttpx.PortBackendConfig, clientCred *nghttpx.TLSCred) (map[string][]byte, *nghttpx.TLSCred, error) {
+	var caCerts map[string][]byte
+
+	for _, svcBackendConfig := range backendConfig {
+		for _, portBackendConfig := range svcBackendConfig {
+			if portBackendConfig.CASecret != "" {
+				if lbc.backendCANamespaces != nil && !lbc.backendCANamespaces[ing.Namespace] {
+					// The CA certificate would be trusted for the backends in the other namespaces.
+					return nil, nil, fmt.Errorf("namespace %v is not allowed to reference backend CA certificate by --backend-ca-namespaces",
+						ing.Namespace)
+				}
+				secretKey := fmt.Sprintf("%v/%v", ing.Namespace, portBackendConfig.CASecret)
+				if _, ok := caCerts[secretKey]; !ok {
+					caCert, err := lbc.getCACertFromSecret(secretKey)
+					if err != nil {
+						return nil, nil, err
+					}
+					if caCerts == nil {
+						caCerts = make(map[string][]byte)
+					}
+					caCerts[secretKey] = caCert
+				}
+			}
+
+			if portBackendConfig.ClientSecret != "" {
+				secretKey := fmt.Sprintf("%v/%v", ing.Namespace, portBackendConfig.ClientSecret)
+				tlsCred, err := lbc.getTLSCredFromSecret(secretKey)
+				if err != nil {
+					return nil, nil, err
+				}
+				switch {
+				case clientCred == nil:
+					clientCred = tlsCred
+				case clientCred.Key.Checksum != tlsCred.Key.Checksum || clientCred.Cert.Checksum != tlsCred.Cert.Checksum:
+					return nil, nil, fmt.Errorf("client certificate in Secret %v differs from the one presented to the other backends; "+
+						"nghttpx supports only one backend client certificate", secretKey)
+				}
+			}
+		}
+	}
+
+	return caCerts, clientCred, nil
+}
+
+// getCACertFromSecret returns CA certificate stored in the Secret denoted by secretKey.
+func (lbc *LoadBalancerController) getCACertFromSecret(secretKey string) ([]byte, error) {
+	ns, name, _ := cache.SplitMetaNamespaceKey(secretKey)
+	secret, err := lbc.secretLister.Secrets(ns).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("Secret %v has been deleted", secretKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not get CA secret %v: %v", secretKey, err)
+	}
+	caCert, ok := secret.Data[caCertKey]
+	if !ok {
+		return nil, fmt.Errorf("Secret %v has no %v", secretKey, caCertKey)
+	}
+	if err := nghttpx.CheckCertificates(caCert); err != nil {
+		return nil, fmt.Errorf("Secret %v contains invalid CA certificate: %v", secretKey, err)
+	}
+	return caCert, nil
+}
+
+// createBackendCACert concatenates CA certificates in caCerts in the ascending order of their keys, and returns nghttpx.ChecksumFile
+// which contains the resulting bundle.  If caCerts is empty, it returns nil.
+func createBackendCACert(dir string, caCerts map[string][]byte) *nghttpx.ChecksumFile {
+	if len(caCerts) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(caCerts))
+	for k := range caCerts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var bundle []byte
+	for _, k := range keys {
+		bundle = append(bundle, caCerts[k]...)
+		if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+			bundle = append(bundle, '\n')
+		}
+	}
+
+	return &nghttpx.ChecksumFile{
+		Path:     nghttpx.BackendCACertPath(dir),
+		Content:  bundle,
+		Checksum: nghttpx.Checksum(bundle),
+	}
+}
+
+// getTLSTicketKeyFiles returns TLS session ticket key files created from the shared Secret.  It returns nil if the Secret is not
+// available, and nghttpx generates keys by itself in that case.
+func (lbc *LoadBalancerController) getTLSTicketKeyFiles() []*nghttpx.ChecksumFile {
+	secret, err := lbc.secretLister.Secrets(lbc.podInfo.PodNamespace).Get(tlsTicketKeySecretName)
+	if err != nil {
+		glog.V(3).Infof("Could not get TLS session ticket key Secret %v/%v: %v", lbc.podInfo.PodNamespace, tlsTicketKeySecretName, err)
+		return nil
+	}
+
+	keys := secret.Data[tlsTicketKeyKey]
+	if err := nghttpx.VerifyTLSTicketKey(keys); err != nil {
+		glog.Warningf("Secret %v/%v contains invalid TLS session ticket keys: %v", secret.Namespace, secret.Name, err)
+		return nil
+	}
+
+	return nghttpx.CreateTLSTicketKeyFiles(lbc.nghttpxConfDir, keys)
+}
+
+// rotateTLSTicketKey creates Secret which contains TLS session ticket keys if it does not exist.  If the keys are older than
+// lbc.tlsTicketKeyPeriod, it promotes the next encryption key to encryption key, generates new next encryption key, and keeps old keys
+// for decryption.  The next encryption key is used for decryption only for one period, so that all controllers have it before it is
+// used for encryption.  This must be called by the leader.
+func (lbc *LoadBalancerController) rotateTLSTicketKey() error {
+	now := time.Now()
+	ns := lbc.podInfo.PodNamespace
+
+	secret, err := lbc.secretLister.Secrets(ns).Get(tlsTicketKeySecretName)
+	if errors.IsNotFound(err) {
+		keys, err := nghttpx.UpdateTLSTicketKey(nil, tlsTicketKeyMaxKeys)
+		if err != nil {
+			return err
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tlsTicketKeySecretName,
+				Namespace: ns,
+				Annotations: map[string]string{
+					tlsTicketKeyUpdateTimestampKey: now.Format(time.RFC3339),
+				},
+			},
+			Data: map[string][]byte{
+				tlsTicketKeyKey: keys,
+			},
+		}
+		if _, err := lbc.clientset.CoreV1().Secrets(ns).Create(secret); err != nil {
+			return fmt.Errorf("Could not create Secret %v/%v: %v", ns, tlsTicketKeySecretName, err)
+		}
+		glog.Infof("Created TLS session ticket key Secret %v/%v", ns, tlsTicketKeySecretName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not get Secret %v/%v: %v", ns, tlsTicketKeySecretName, err)
+	}
+
+	keys := secret.Data[tlsTicketKeyKey]
+	if err := nghttpx.VerifyTLSTicketKey(keys); err != nil {
+		glog.Warningf("Secret %v/%v contains invalid TLS session ticket keys, and they are replaced: %v", ns, tlsTicketKeySecretName, err)
+		keys = nil
+	} else if t, err := time.Parse(time.RFC3339, secret.Annotations[tlsTicketKeyUpdateTimestampKey]); err == nil && now.Before(t.Add(lbc.tlsTicketKeyPeriod)) {
+		return nil
+	}
+
+	keys, err = nghttpx.UpdateTLSTicketKey(keys, tlsTicketKeyMaxKeys)
+	if err != nil {
+		return err
+	}
+
+	updatedSecret := *secret
+	updatedSecret.Annotations = copyStringMap(secret.Annotations)
+	updatedSecret.Annotations[tlsTicketKeyUpdateTimestampKey] = now.Format(time.RFC3339)
+	updatedSecret.Data = make(map[string][]byte)
+	for k, v := range secret.Data {
+		updatedSecret.Data[k] = v
+	}
+	updatedSecret.Data[tlsTicketKeyKey] = keys
+
+	if _, err := lbc.clientset.CoreV1().Secrets(ns).Update(&updatedSecret); err != nil {
+		return fmt.Errorf("Could not update Secret %v/%v: %v", ns, tlsTicketKeySecretName, err)
+	}
+
+	glog.Infof("Rotated TLS session ticket key in Secret %v/%v", ns, tlsTicketKeySecretName)
+
+	return nil
+}
+
 // createTLSCredFromSecret creates nghttpx.TLSCred from secret.
 func (lbc *LoadBalancerController) createTLSCredFromSecret(secret *v1.Secret) (*nghttpx.TLSCred, error) {
 	cert, ok := secret.Data[v1.TLSCertKey]
@@ -1034,12 +1945,10 @@ func (lbc *LoadBalancerController) createTLSCredFromSecret(secret *v1.Secret) (*
 		return nil, fmt.Errorf("Secret %v/%v has no private key", secret.Namespace, secret.Name)
 	}
 
-	if _, err := nghttpx.CommonNames(cert); err != nil {
-		return nil, fmt.Errorf("No valid TLS certificate found in Secret %v/%v: %v", secret.Namespace, secret.Name, err)
-	}
-
-	if err := nghttpx.CheckPrivateKey(key); err != nil {
-		return nil, fmt.Errorf("No valid TLS private key found in Secret %v/%v: %v", secret.Namespace, secret.Name, err)
+	// VerifyKeyPair may reorder certificate chain so that nghttpx sends them in the correct order.
+	cert, err := nghttpx.VerifyKeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("No valid TLS certificate and private key found in Secret %v/%v: %v", secret.Namespace, secret.Name, err)
 	}
 
 	// OCSP response in TLS secret is optional feature.
@@ -1053,18 +1962,80 @@ func (lbc *LoadBalancerController) createTLSCredFromSecret(secret *v1.Secret) (*
 	return tlsCred, nil
 }
 
+// clientCASecretKey returns the key of the Secret, in the form of namespace/name, which contains CA certificate to verify client certificate.
+// If the Secret is not specified in cm, it returns empty string.  If namespace is omitted, the namespace of cm is used.
+func clientCASecretKey(cm *v1.ConfigMap) string {
+	secretKey := strings.TrimSpace(cm.Data[nghttpx.NghttpxClientCASecretKey])
+	if secretKey == "" {
+		return ""
+	}
+	if !strings.Contains(secretKey, "/") {
+		return fmt.Sprintf("%v/%v", cm.Namespace, secretKey)
+	}
+	return secretKey
+}
+
+// getClientCACert returns the CA certificate to verify client certificate from the Secret specified in cm.  If no Secret is specified, or
+// TLS is not enabled, it returns nil.  If the Secret is specified, but it cannot be processed, it returns an error, so that client
+// certificate verification is not turned off silently.
+func (lbc *LoadBalancerController) getClientCACert(ingConfig *nghttpx.IngressConfig, cm *v1.ConfigMap) (*nghttpx.ChecksumFile, error) {
+	secretKey := clientCASecretKey(cm)
+	if secretKey == "" {
+		return nil, nil
+	}
+
+	if !ingConfig.TLS {
+		glog.Warningf("Client certificate verification is not enabled because TLS is not configured")
+		return nil, nil
+	}
+
+	ns, name, err := cache.SplitMetaNamespaceKey(secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse client CA Secret %v: %v", secretKey, err)
+	}
+	secret, err := lbc.secretLister.Secrets(ns).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("Client CA Secret %v has been deleted", secretKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not get client CA Secret %v: %v", secretKey, err)
+	}
+
+	caCert, ok := secret.Data[caCertKey]
+	if !ok {
+		return nil, fmt.Errorf("Secret %v has no %v", secretKey, caCertKey)
+	}
+
+	if err := nghttpx.CheckCertificates(caCert); err != nil {
+		return nil, fmt.Errorf("No valid CA certificate found in Secret %v: %v", secretKey, err)
+	}
+
+	return nghttpx.CreateCACert(lbc.nghttpxConfDir, nghttpx.TLSCredPrefix(secret), caCert), nil
+}
+
 func (lbc *LoadBalancerController) secretReferenced(namespace, name string) bool {
-	if lbc.defaultTLSSecret == fmt.Sprintf("%v/%v", namespace, name) {
+	key := fmt.Sprintf("%v/%v", namespace, name)
+	if lbc.defaultTLSSecret == key {
 		return true
 	}
 
+	if lbc.shareTLSTicketKey && namespace == lbc.podInfo.PodNamespace && name == tlsTicketKeySecretName {
+		return true
+	}
+
+	if lbc.ngxConfigMap != "" {
+		if cm, err := lbc.getConfigMap(lbc.ngxConfigMap); err == nil && clientCASecretKey(cm) == key {
+			return true
+		}
+	}
+
 	ings, err := lbc.ingLister.Ingresses(namespace).List(labels.Everything())
 	if err != nil {
 		glog.Errorf("Could not list Ingress namespace=%v: %v", namespace, err)
 		return false
 	}
 	for _, ing := range ings {
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
 		for i, _ := range ing.Spec.TLS {
@@ -1073,6 +2044,13 @@ func (lbc *LoadBalancerController) secretReferenced(namespace, name string) bool
 				return true
 			}
 		}
+		for _, svcBackendConfig := range ingressAnnotation(ing.ObjectMeta.Annotations).getBackendConfig() {
+			for _, portBackendConfig := range svcBackendConfig {
+				if portBackendConfig.CASecret == name || portBackendConfig.ClientSecret == name {
+					return true
+				}
+			}
+		}
 	}
 	return false
 }
@@ -1146,6 +2124,13 @@ func (lbc *LoadBalancerController) getEndpoints(s *v1.Service, servicePort *v1.S
 					SNI:      portBackendConfig.SNI,
 					DNS:      portBackendConfig.DNS,
 					Affinity: portBackendConfig.Affinity,
+
+					AffinityCookieName:   portBackendConfig.AffinityCookieName,
+					AffinityCookiePath:   portBackendConfig.AffinityCookiePath,
+					AffinityCookieSecure: portBackendConfig.AffinityCookieSecure,
+				}
+				if lbc.topology != nil && epAddress.NodeName != nil {
+					ups.Zone = lbc.getNodeZone(*epAddress.NodeName)
 				}
 				upsServers = append(upsServers, ups)
 			}
@@ -1156,6 +2141,56 @@ func (lbc *LoadBalancerController) getEndpoints(s *v1.Service, servicePort *v1.S
 	return upsServers
 }
 
+// getExternalNameBackend returns the backend for ExternalName Service svc.  The external name is resolved by nghttpx dynamically.  The port
+// is servicePort.TargetPort if it is a number.  Otherwise, it is servicePort.Port because there is no Pod to look up the named port.
+func getExternalNameBackend(svc *v1.Service, servicePort *v1.ServicePort, portBackendConfig *nghttpx.PortBackendConfig) []nghttpx.UpstreamServer {
+	if svc.Spec.ExternalName == "" {
+		glog.Warningf("ExternalName Service %v/%v has empty externalName", svc.Namespace, svc.Name)
+		return nil
+	}
+
+	port := servicePort.Port
+	if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal != 0 {
+		port = servicePort.TargetPort.IntVal
+	}
+
+	return []nghttpx.UpstreamServer{
+		{
+			Address:  svc.Spec.ExternalName,
+			Port:     strconv.Itoa(int(port)),
+			Protocol: portBackendConfig.Proto,
+			TLS:      portBackendConfig.TLS,
+			SNI:      portBackendConfig.SNI,
+			DNS:      true,
+			Affinity: portBackendConfig.Affinity,
+
+			AffinityCookieName:   portBackendConfig.AffinityCookieName,
+			AffinityCookiePath:   portBackendConfig.AffinityCookiePath,
+			AffinityCookieSecure: portBackendConfig.AffinityCookieSecure,
+		},
+	}
+}
+
+// getClusterIPBackend returns the backend which is ClusterIP and servicePort of svc.  kube-proxy or service mesh distributes requests to
+// the endpoints.
+func getClusterIPBackend(svc *v1.Service, servicePort *v1.ServicePort, portBackendConfig *nghttpx.PortBackendConfig) []nghttpx.UpstreamServer {
+	return []nghttpx.UpstreamServer{
+		{
+			Address:  svc.Spec.ClusterIP,
+			Port:     strconv.Itoa(int(servicePort.Port)),
+			Protocol: portBackendConfig.Proto,
+			TLS:      portBackendConfig.TLS,
+			SNI:      portBackendConfig.SNI,
+			DNS:      portBackendConfig.DNS,
+			Affinity: portBackendConfig.Affinity,
+
+			AffinityCookieName:   portBackendConfig.AffinityCookieName,
+			AffinityCookiePath:   portBackendConfig.AffinityCookiePath,
+			AffinityCookieSecure: portBackendConfig.AffinityCookieSecure,
+		},
+	}
+}
+
 // getNamedPortFromPod returns port number from Pod sharing the same port name with servicePort.
 func (lbc *LoadBalancerController) getNamedPortFromPod(svc *v1.Service, servicePort *v1.ServicePort) (int32, error) {
 	pods, err := lbc.podLister.Pods(svc.Namespace).List(labels.Set(svc.Spec.Selector).AsSelector())
@@ -1175,7 +2210,8 @@ func (lbc *LoadBalancerController) getNamedPortFromPod(svc *v1.Service, serviceP
 	return int32(port), nil
 }
 
-// Stop commences shutting down the loadbalancer controller.
+// Stop commences shutting down the loadbalancer controller.  First, this address is removed from Ingress status, and after drain period,
+// nghttpx is stopped.
 func (lbc *LoadBalancerController) Stop() {
 	// Stop is invoked from the http endpoint.
 	lbc.stopLock.Lock()
@@ -1190,19 +2226,45 @@ func (lbc *LoadBalancerController) Stop() {
 	glog.Infof("Commencing shutting down")
 
 	lbc.shutdown = true
-	close(lbc.stopCh)
+	close(lbc.shutdownCh)
+}
+
+// ShuttingDown returns true if shutting down has commenced.
+func (lbc *LoadBalancerController) ShuttingDown() bool {
+	lbc.stopLock.Lock()
+	defer lbc.stopLock.Unlock()
+
+	return lbc.shutdown
+}
+
+// AccessLogWriter returns io.Writer which receives nghttpx access log for outlier detection.  It returns nil if outlier detection is
+// disabled.
+func (lbc *LoadBalancerController) AccessLogWriter() io.Writer {
+	if lbc.outlierDetector == nil {
+		return nil
+	}
+	return lbc.outlierDetector
 }
 
 // Run starts the loadbalancer controller.
 func (lbc *LoadBalancerController) Run() {
 	glog.Infof("Starting nghttpx loadbalancer controller")
 
+	if caps, err := lbc.nghttpx.DetectCapabilities(lbc.nghttpxExecPath); err != nil {
+		glog.Errorf("Could not detect nghttpx capabilities; newer features are disabled: %v", err)
+	} else {
+		glog.Infof("Detected nghttpx %v", caps.Version)
+		glog.V(4).Infof("nghttpx capabilities: %v", caps)
+	}
+
+	lbc.disableUnsupportedFeatures(lbc.nghttpx.Capabilities())
+
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		lbc.nghttpx.Start(lbc.nghttpxExecPath, nghttpx.NghttpxConfigPath(lbc.nghttpxConfDir), lbc.stopCh)
+		lbc.nghttpx.Start(lbc.nghttpxExecPath, nghttpx.NghttpxConfigPath(lbc.nghttpxConfDir), lbc.stopCh, lbc.nghttpxQuitTimeout)
 	}()
 
 	go lbc.ingController.Run(lbc.stopCh)
@@ -1212,6 +2274,9 @@ func (lbc *LoadBalancerController) Run() {
 	go lbc.cmController.Run(lbc.stopCh)
 	go lbc.podController.Run(lbc.stopCh)
 	go lbc.nodeController.Run(lbc.stopCh)
+	if lbc.nsController != nil {
+		go lbc.nsController.Run(lbc.stopCh)
+	}
 
 	ready := make(chan struct{})
 	go lbc.waitForControllerToSync(ready)
@@ -1219,16 +2284,45 @@ func (lbc *LoadBalancerController) Run() {
 
 	go lbc.worker()
 
-	wg.Add(1)
+	go lbc.leaderElector.Run(lbc.stopCh)
+
+	if lbc.shareTLSTicketKey {
+		go wait.Until(func() {
+			if !lbc.leaderElector.IsLeader() {
+				return
+			}
+			if err := lbc.rotateTLSTicketKey(); err != nil {
+				glog.Errorf("Could not rotate TLS session ticket key: %v", err)
+			}
+		}, tlsTicketKeyCheckPeriod, lbc.stopCh)
+	}
+
+	go wait.Until(lbc.updateIngressStatusAnnotations, ingressStatusUpdatePeriod, lbc.stopCh)
+
+	if lbc.outlierDetector != nil {
+		go wait.Until(lbc.outlierDetector.evaluate, outlierDetectionEvaluatePeriod, lbc.stopCh)
+	}
+
+	syncIngressDoneCh := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		lbc.syncIngress(lbc.stopCh)
+		defer close(syncIngressDoneCh)
+		lbc.syncIngress(lbc.shutdownCh)
 	}()
 
-	<-lbc.stopCh
+	<-lbc.shutdownCh
 
 	glog.Infof("Shutting down nghttpx loadbalancer controller")
 
+	// syncIngress removes this address from Ingress status before it returns.
+	<-syncIngressDoneCh
+
+	if lbc.drainPeriod > 0 {
+		glog.Infof("Waiting for %v to drain connections", lbc.drainPeriod)
+		time.Sleep(lbc.drainPeriod)
+	}
+
+	close(lbc.stopCh)
+
 	lbc.syncQueue.ShutDown()
 
 	wg.Wait()
@@ -1243,7 +2337,7 @@ Loop:
 		}
 
 		select {
-		case <-lbc.stopCh:
+		case <-lbc.shutdownCh:
 			break Loop
 		case <-time.After(podStoreSyncedPollPeriod):
 		}
@@ -1252,10 +2346,44 @@ Loop:
 	close(ready)
 }
 
+// retryOrForget requeues key with exponential backoff if requeue is true.  Otherwise, or if retries have been exhausted, it resets the
+// backoff of key.
 func (lbc *LoadBalancerController) retryOrForget(key interface{}, requeue bool) {
-	if requeue {
-		lbc.syncQueue.Add(key)
+	if !requeue {
+		lbc.syncQueue.Forget(key)
+		return
+	}
+
+	if n := lbc.syncQueue.NumRequeues(key); lbc.syncMaxRetries > 0 && n >= lbc.syncMaxRetries {
+		glog.Errorf("Giving up sync of %v after %v retries", key, n)
+		syncRetriesExhausted.Add(1)
+		lbc.syncQueue.Forget(key)
+		return
+	}
+
+	syncRetries.Add(1)
+	lbc.syncQueue.AddRateLimited(key)
+}
+
+// ingressManaged returns true if this controller should process ing.  ing must be in a watched namespace, and have the Ingress class which
+// this controller is responsible for.
+func (lbc *LoadBalancerController) ingressManaged(ing *extensions.Ingress) bool {
+	return lbc.namespaceWatched(ing.Namespace) && lbc.validateIngressClass(ing)
+}
+
+// namespaceWatched returns true if namespace is watched for Ingress.
+func (lbc *LoadBalancerController) namespaceWatched(namespace string) bool {
+	if lbc.watchNamespaces != nil && !lbc.watchNamespaces[namespace] {
+		return false
+	}
+	if lbc.watchNamespaceSelector == nil {
+		return true
+	}
+	ns, err := lbc.nsLister.Get(namespace)
+	if err != nil {
+		return false
 	}
+	return lbc.watchNamespaceSelector.Matches(labels.Set(ns.Labels))
 }
 
 // validateIngressClass checks whether this controller should process ing or not.  If ing has "kubernetes.io/ingress.class" annotation, its
@@ -1324,12 +2452,12 @@ func (lbc *LoadBalancerController) updateIngressStatus(lbIngs []v1.LoadBalancerI
 
 	for _, ing := range ings {
 		select {
-		case <-lbc.stopCh:
+		case <-lbc.shutdownCh:
 			return nil
 		default:
 		}
 
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
 
@@ -1434,7 +2562,7 @@ func (lbc *LoadBalancerController) removeAddressFromLoadBalancerIngress() error
 	}
 
 	for _, ing := range ings {
-		if !lbc.validateIngressClass(ing) {
+		if !lbc.ingressManaged(ing) {
 			continue
 		}
 
@@ -0,0 +1,126 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+var (
+	// syncEvents is the number of events which request sync.
+	syncEvents = expvar.NewInt("syncEvents")
+	// syncEventsCoalesced is the number of events which are coalesced into the pending sync.
+	syncEventsCoalesced = expvar.NewInt("syncEventsCoalesced")
+	// syncDeferredUpdates is the number of configuration updates deferred because of the update budget.  It is keyed by the kind of
+	// change, "main" or "backend".
+	syncDeferredUpdates = expvar.NewMap("syncDeferredUpdates")
+)
+
+// errConfigChangeDeferred is returned from sync when applying configuration is deferred because of the update budget.
+var errConfigChangeDeferred = errors.New("configuration change is deferred")
+
+// syncDebouncer coalesces the events which request sync.  The sync is delayed until no event comes in window, but no longer than
+// maxDelay since the first event.
+type syncDebouncer struct {
+	window   time.Duration
+	maxDelay time.Duration
+
+	mu sync.Mutex
+	// pending is true if there are events which have not been processed by sync yet.
+	pending bool
+	// firstEvent is the time when the first pending event came.
+	firstEvent time.Time
+	// lastEvent is the time when the last pending event came.
+	lastEvent time.Time
+}
+
+// newSyncDebouncer returns new syncDebouncer.
+func newSyncDebouncer(window, maxDelay time.Duration) *syncDebouncer {
+	if maxDelay < window {
+		maxDelay = window
+	}
+	return &syncDebouncer{
+		window:   window,
+		maxDelay: maxDelay,
+	}
+}
+
+// add records an event which came at now.  It returns true if the event is coalesced into the pending events.
+func (d *syncDebouncer) add(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastEvent = now
+	if d.pending {
+		return true
+	}
+
+	d.pending = true
+	d.firstEvent = now
+
+	return false
+}
+
+// take returns the remaining duration before the pending events should be processed.  If it returns 0, the pending events are
+// cleared, and sync should be done now.
+func (d *syncDebouncer) take(now time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.pending {
+		return 0
+	}
+
+	deadline := d.lastEvent.Add(d.window)
+	if maxDeadline := d.firstEvent.Add(d.maxDelay); maxDeadline.Before(deadline) {
+		deadline = maxDeadline
+	}
+
+	if delay := deadline.Sub(now); delay > 0 {
+		return delay
+	}
+
+	d.pending = false
+
+	return 0
+}
+
+// configChangeDelay returns the duration to wait before change can be applied without exceeding its update budget.  It returns 0 if
+// change can be applied now.
+func (lbc *LoadBalancerController) configChangeDelay(change nghttpx.ConfigChange, now time.Time) time.Duration {
+	var next time.Time
+	switch change {
+	case nghttpx.MainConfigChanged:
+		next = lbc.lastMainReload.Add(lbc.reloadMinInterval)
+	case nghttpx.BackendConfigChanged:
+		next = lbc.lastBackendUpdate.Add(lbc.backendUpdateMinInterval)
+	default:
+		return 0
+	}
+
+	if delay := next.Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// recordConfigChange records that change was applied at now.  Main configuration reload also updates backend configuration.
+func (lbc *LoadBalancerController) recordConfigChange(change nghttpx.ConfigChange, now time.Time) {
+	switch change {
+	case nghttpx.MainConfigChanged:
+		lbc.lastMainReload = now
+		lbc.lastBackendUpdate = now
+	case nghttpx.BackendConfigChanged:
+		lbc.lastBackendUpdate = now
+	}
+}
@@ -0,0 +1,51 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSyncDebouncer verifies syncDebouncer.
+func TestSyncDebouncer(t *testing.T) {
+	d := newSyncDebouncer(time.Second, 3*time.Second)
+
+	now := time.Now()
+
+	if got, want := d.take(now), time.Duration(0); got != want {
+		t.Errorf("d.take(now) = %v, want %v", got, want)
+	}
+
+	if got, want := d.add(now), false; got != want {
+		t.Errorf("d.add(now) = %v, want %v", got, want)
+	}
+	if got, want := d.add(now.Add(500*time.Millisecond)), true; got != want {
+		t.Errorf("d.add(now+500ms) = %v, want %v", got, want)
+	}
+
+	// The last event extends the window.
+	if got, want := d.take(now.Add(time.Second)), 500*time.Millisecond; got != want {
+		t.Errorf("d.take(now+1s) = %v, want %v", got, want)
+	}
+
+	// Events keep coming, but the sync is not delayed beyond maxDelay.
+	d.add(now.Add(1800 * time.Millisecond))
+	d.add(now.Add(2600 * time.Millisecond))
+	if got, want := d.take(now.Add(2700*time.Millisecond)), 300*time.Millisecond; got != want {
+		t.Errorf("d.take(now+2.7s) = %v, want %v", got, want)
+	}
+	if got, want := d.take(now.Add(3*time.Second)), time.Duration(0); got != want {
+		t.Errorf("d.take(now+3s) = %v, want %v", got, want)
+	}
+
+	// Pending events have been cleared.
+	if got, want := d.add(now.Add(4*time.Second)), false; got != want {
+		t.Errorf("d.add(now+4s) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+const (
+	// defaultHealthCheckInterval is the default interval between health checks of an endpoint.
+	defaultHealthCheckInterval = 10 * time.Second
+	// defaultHealthCheckTimeout is the default timeout of a health check request.
+	defaultHealthCheckTimeout = 2 * time.Second
+	// defaultHealthCheckRise is the default number of consecutive successful health checks to make an unhealthy endpoint healthy.
+	defaultHealthCheckRise = 2
+	// defaultHealthCheckFall is the default number of consecutive failed health checks to make a healthy endpoint unhealthy.
+	defaultHealthCheckFall = 3
+)
+
+// healthCheckConfig is the configuration of active health checking of the endpoints of a Service.
+type healthCheckConfig struct {
+	// Path is the request path of health check.
+	Path string
+	// Interval is the interval between health checks.
+	Interval time.Duration
+	// Timeout is the timeout of a health check request.
+	Timeout time.Duration
+	// Rise is the number of consecutive successful health checks to make an unhealthy endpoint healthy.
+	Rise int
+	// Fall is the number of consecutive failed health checks to make a healthy endpoint unhealthy.
+	Fall int
+}
+
+// parseHealthCheckConfig parses health check configuration s, which is a serialized JSON dictionary, and returns healthCheckConfig with
+// defaults applied.
+func parseHealthCheckConfig(s string) (healthCheckConfig, error) {
+	var in struct {
+		Path     string `json:"path"`
+		Interval string `json:"interval"`
+		Timeout  string `json:"timeout"`
+		Rise     int    `json:"rise"`
+		Fall     int    `json:"fall"`
+	}
+
+	if err := json.Unmarshal([]byte(s), &in); err != nil {
+		return healthCheckConfig{}, err
+	}
+
+	config := healthCheckConfig{
+		Path:     in.Path,
+		Interval: defaultHealthCheckInterval,
+		Timeout:  defaultHealthCheckTimeout,
+		Rise:     defaultHealthCheckRise,
+		Fall:     defaultHealthCheckFall,
+	}
+
+	if !strings.HasPrefix(config.Path, "/") {
+		return healthCheckConfig{}, fmt.Errorf("path must start with /: %q", config.Path)
+	}
+
+	for _, d := range []struct {
+		name string
+		in   string
+		dst  *time.Duration
+	}{
+		{"interval", in.Interval, &config.Interval},
+		{"timeout", in.Timeout, &config.Timeout},
+	} {
+		if d.in == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.in)
+		if err != nil {
+			return healthCheckConfig{}, fmt.Errorf("invalid %v: %v", d.name, err)
+		}
+		if v <= 0 {
+			return healthCheckConfig{}, fmt.Errorf("%v must be positive: %v", d.name, d.in)
+		}
+		*d.dst = v
+	}
+
+	if in.Rise < 0 || in.Fall < 0 {
+		return healthCheckConfig{}, fmt.Errorf("rise and fall must not be negative")
+	}
+	if in.Rise > 0 {
+		config.Rise = in.Rise
+	}
+	if in.Fall > 0 {
+		config.Fall = in.Fall
+	}
+
+	return config, nil
+}
+
+// healthCheckTarget is an endpoint which is checked by healthChecker.
+type healthCheckTarget struct {
+	Address string
+	Port    string
+	TLS     bool
+	SNI     string
+	Config  healthCheckConfig
+}
+
+// healthCheckState is the health state of healthCheckTarget.
+type healthCheckState struct {
+	healthy   bool
+	successes int
+	failures  int
+	// generation is the generation of healthChecker when this target was last used.
+	generation int
+	stopCh     chan struct{}
+}
+
+// healthChecker checks the health of endpoints periodically, and calls onChange when the health of an endpoint changes.  New endpoint
+// is considered healthy until it fails health checks.
+type healthChecker struct {
+	// stopCh stops all health checks when it is closed.
+	stopCh <-chan struct{}
+	// onChange is called when the health of an endpoint changes.
+	onChange func()
+	// probe checks the health of target.  It returns non-nil error if target is unhealthy.
+	probe func(target healthCheckTarget) error
+
+	mu sync.Mutex
+	// targets is the endpoints which are being checked.
+	targets map[healthCheckTarget]*healthCheckState
+	// generation is incremented each time prune is called.
+	generation int
+}
+
+// newHealthChecker returns new healthChecker.
+func newHealthChecker(stopCh <-chan struct{}, onChange func()) *healthChecker {
+	return &healthChecker{
+		stopCh:   stopCh,
+		onChange: onChange,
+		probe:    probeHTTP,
+		targets:  make(map[healthCheckTarget]*healthCheckState),
+	}
+}
+
+// filter returns the healthy backends in backends of the service port denoted by name.  It starts health checking of the backends which
+// are not checked yet.  If no backend is healthy, it returns backends as is, so that an upstream never becomes empty.
+func (hc *healthChecker) filter(name string, config healthCheckConfig, backends []nghttpx.UpstreamServer) []nghttpx.UpstreamServer {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	var healthy []nghttpx.UpstreamServer
+	for _, backend := range backends {
+		target := healthCheckTarget{
+			Address: backend.Address,
+			Port:    backend.Port,
+			TLS:     backend.TLS,
+			SNI:     backend.SNI,
+			Config:  config,
+		}
+
+		st, ok := hc.targets[target]
+		if !ok {
+			st = &healthCheckState{
+				healthy: true,
+				stopCh:  make(chan struct{}),
+			}
+			hc.targets[target] = st
+			go hc.run(target, st)
+		}
+		st.generation = hc.generation
+
+		if st.healthy {
+			healthy = append(healthy, backend)
+		}
+	}
+
+	if len(healthy) == 0 && len(backends) > 0 {
+		glog.Warningf("All backends of %v are unhealthy; use all of them", name)
+		return backends
+	}
+
+	return healthy
+}
+
+// prune stops health checking of the endpoints which have not been passed to filter since the last call of prune.
+func (hc *healthChecker) prune() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	for target, st := range hc.targets {
+		if st.generation != hc.generation {
+			glog.V(4).Infof("Stop health checking %v:%v", target.Address, target.Port)
+			close(st.stopCh)
+			delete(hc.targets, target)
+		}
+	}
+
+	hc.generation++
+}
+
+// run checks the health of target periodically until it is pruned, or healthChecker is stopped.
+func (hc *healthChecker) run(target healthCheckTarget, st *healthCheckState) {
+	ticker := time.NewTicker(target.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.stopCh:
+			return
+		case <-st.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		if hc.record(target, st, hc.probe(target)) {
+			hc.onChange()
+		}
+	}
+}
+
+// record records the result of health check of target, and returns true if its health has changed.
+func (hc *healthChecker) record(target healthCheckTarget, st *healthCheckState, err error) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if err == nil {
+		st.successes++
+		st.failures = 0
+		if !st.healthy && st.successes >= target.Config.Rise {
+			glog.Infof("Backend %v:%v became healthy", target.Address, target.Port)
+			st.healthy = true
+			return true
+		}
+		return false
+	}
+
+	glog.V(4).Infof("Health check of backend %v:%v failed: %v", target.Address, target.Port, err)
+
+	st.failures++
+	st.successes = 0
+	if st.healthy && st.failures >= target.Config.Fall {
+		glog.Warningf("Backend %v:%v became unhealthy: %v", target.Address, target.Port, err)
+		st.healthy = false
+		return true
+	}
+	return false
+}
+
+// probeHTTP sends HTTP GET request to target.  It succeeds if the response status code is 2xx or 3xx.  Server certificate is not
+// verified because the purpose of the request is just checking the health.
+func probeHTTP(target healthCheckTarget) error {
+	scheme := "http"
+	if target.TLS {
+		scheme = "https"
+	}
+
+	client := &http.Client{
+		Timeout: target.Config.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         target.SNI,
+				InsecureSkipVerify: true,
+			},
+			DisableKeepAlives: true,
+		},
+		// Do not follow redirects.  3xx response is considered healthy.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v://%v%v", scheme, net.JoinHostPort(target.Address, target.Port),
+		target.Config.Path), nil)
+	if err != nil {
+		return err
+	}
+	if target.SNI != "" {
+		req.Host = target.SNI
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+// TestParseHealthCheckConfig verifies parseHealthCheckConfig.
+func TestParseHealthCheckConfig(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    healthCheckConfig
+		wantErr bool
+	}{
+		{
+			in: `{"path": "/healthz"}`,
+			want: healthCheckConfig{
+				Path:     "/healthz",
+				Interval: defaultHealthCheckInterval,
+				Timeout:  defaultHealthCheckTimeout,
+				Rise:     defaultHealthCheckRise,
+				Fall:     defaultHealthCheckFall,
+			},
+		},
+		{
+			in: `{"path": "/ready", "interval": "5s", "timeout": "500ms", "rise": 1, "fall": 5}`,
+			want: healthCheckConfig{
+				Path:     "/ready",
+				Interval: 5 * time.Second,
+				Timeout:  500 * time.Millisecond,
+				Rise:     1,
+				Fall:     5,
+			},
+		},
+		{
+			in:      `{"interval": "5s"}`,
+			wantErr: true,
+		},
+		{
+			in:      `{"path": "/healthz", "interval": "five seconds"}`,
+			wantErr: true,
+		},
+		{
+			in:      `{"path": "/healthz", "timeout": "-1s"}`,
+			wantErr: true,
+		},
+		{
+			in:      `{"path": "/healthz", "fall": -1}`,
+			wantErr: true,
+		},
+		{
+			in:      `path=/healthz`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHealthCheckConfig(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseHealthCheckConfig(%q) succeeded, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHealthCheckConfig(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHealthCheckConfig(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestHealthCheckerRecord verifies that the health changes according to rise and fall thresholds.
+func TestHealthCheckerRecord(t *testing.T) {
+	hc := newHealthChecker(make(chan struct{}), func() {})
+	target := healthCheckTarget{
+		Address: "192.168.10.1",
+		Port:    "80",
+		Config: healthCheckConfig{
+			Rise: 2,
+			Fall: 3,
+		},
+	}
+	st := &healthCheckState{healthy: true}
+
+	errProbe := errors.New("probe failed")
+
+	for i, tt := range []struct {
+		err         error
+		wantChanged bool
+		wantHealthy bool
+	}{
+		{errProbe, false, true},
+		{errProbe, false, true},
+		{nil, false, true},
+		{errProbe, false, true},
+		{errProbe, false, true},
+		{errProbe, true, false},
+		{errProbe, false, false},
+		{nil, false, false},
+		{nil, true, true},
+		{nil, false, true},
+	} {
+		if got, want := hc.record(target, st, tt.err), tt.wantChanged; got != want {
+			t.Errorf("#%v: hc.record(...) = %v, want %v", i, got, want)
+		}
+		if got, want := st.healthy, tt.wantHealthy; got != want {
+			t.Errorf("#%v: st.healthy = %v, want %v", i, got, want)
+		}
+	}
+}
+
+// TestHealthCheckerFilter verifies that filter removes unhealthy backends, but keeps at least one backend, and prune stops health
+// checking of unused backends.
+func TestHealthCheckerFilter(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	hc := newHealthChecker(stopCh, func() {})
+	config := healthCheckConfig{
+		Path:     "/healthz",
+		Interval: time.Hour,
+		Timeout:  time.Second,
+		Rise:     1,
+		Fall:     1,
+	}
+	backends := []nghttpx.UpstreamServer{
+		{Address: "192.168.10.1", Port: "80"},
+		{Address: "192.168.10.2", Port: "80"},
+	}
+
+	if got, want := hc.filter("default/alpha,80", config, backends), backends; !reflect.DeepEqual(got, want) {
+		t.Errorf("hc.filter(...) = %+v, want %+v", got, want)
+	}
+
+	setHealthy := func(backend nghttpx.UpstreamServer, healthy bool) {
+		hc.targets[healthCheckTarget{Address: backend.Address, Port: backend.Port, Config: config}].healthy = healthy
+	}
+
+	setHealthy(backends[0], false)
+
+	if got, want := hc.filter("default/alpha,80", config, backends), backends[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("hc.filter(...) = %+v, want %+v", got, want)
+	}
+
+	setHealthy(backends[1], false)
+
+	// All backends are unhealthy; they are all used.
+	if got, want := hc.filter("default/alpha,80", config, backends), backends; !reflect.DeepEqual(got, want) {
+		t.Errorf("hc.filter(...) = %+v, want %+v", got, want)
+	}
+
+	hc.prune()
+	hc.filter("default/alpha,80", config, backends[:1])
+	hc.prune()
+
+	if got, want := len(hc.targets), 1; got != want {
+		t.Errorf("len(hc.targets) = %v, want %v", got, want)
+	}
+}
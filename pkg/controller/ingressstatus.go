@@ -0,0 +1,297 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+const (
+	// ingressStatusUpdatePeriod is the interval that the leader writes the status annotation of Ingresses.
+	ingressStatusUpdatePeriod = 10 * time.Second
+	// configRevisionLength is the length of configuration revision recorded in the status annotation.
+	configRevisionLength = 16
+)
+
+// ingressState is the state of an Ingress recorded in the status annotation.
+type ingressState string
+
+const (
+	// ingressStateProgrammed means that all rules of an Ingress are programmed into nghttpx.
+	ingressStateProgrammed ingressState = "Programmed"
+	// ingressStateDegraded means that some rules of an Ingress are not programmed into nghttpx, or some of their settings are not
+	// honored.
+	ingressStateDegraded ingressState = "Degraded"
+	// ingressStateRejected means that no rule of an Ingress is programmed into nghttpx.
+	ingressStateRejected ingressState = "Rejected"
+)
+
+// ingressStatus is the value of the status annotation.
+type ingressStatus struct {
+	State ingressState `json:"state"`
+	// Reason describes why an Ingress is degraded or rejected.
+	Reason string `json:"reason,omitempty"`
+	// Conflicts is the sorted list of namespace/name of Ingresses which win the conflict with an Ingress.
+	Conflicts []string `json:"conflicts,omitempty"`
+	// ConfigRevision identifies the routing configuration generated from an Ingress.  It excludes backend addresses.
+	ConfigRevision string `json:"configRevision,omitempty"`
+	// LastAppliedTime is the time when the configuration was last applied to nghttpx successfully.
+	LastAppliedTime time.Time `json:"lastAppliedTime"`
+}
+
+// equivalent returns true if st and other are the same except for LastAppliedTime.  The status annotation is not updated only because
+// LastAppliedTime changes.
+func (st ingressStatus) equivalent(other ingressStatus) bool {
+	return st.State == other.State && st.Reason == other.Reason && strings.Join(st.Conflicts, ",") == strings.Join(other.Conflicts, ",") &&
+		st.ConfigRevision == other.ConfigRevision
+}
+
+// ingressProgramResult is the result of processing an Ingress to generate nghttpx configuration.
+type ingressProgramResult struct {
+	// rules is the number of rules including default backend.
+	rules int
+	// programmed is the number of rules which are programmed.
+	programmed int
+	// rejectReason is non-empty if the whole Ingress is rejected.
+	rejectReason string
+	// problems is the reasons why some rules are not programmed, or some settings are not honored.
+	problems []string
+	// winners is the set of namespace/name of Ingresses which win the conflict with the Ingress.
+	winners map[string]bool
+}
+
+// reject marks the whole Ingress rejected.
+func (r *ingressProgramResult) reject(format string, args ...interface{}) {
+	r.rejectReason = fmt.Sprintf(format, args...)
+}
+
+// fail records that a rule is not programmed.
+func (r *ingressProgramResult) fail(format string, args ...interface{}) {
+	r.rules++
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+// warn records that a rule is programmed, but some of its settings are not honored.  The same warning is recorded only once.
+func (r *ingressProgramResult) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, p := range r.problems {
+		if p == msg {
+			return
+		}
+	}
+	r.problems = append(r.problems, msg)
+}
+
+// conflict records that a rule is not programmed because winner defines the same rule.
+func (r *ingressProgramResult) conflict(winner *extensions.Ingress, format string, args ...interface{}) {
+	r.fail(format, args...)
+	if r.winners == nil {
+		r.winners = make(map[string]bool)
+	}
+	r.winners[fmt.Sprintf("%v/%v", winner.Namespace, winner.Name)] = true
+}
+
+// conflicts returns the sorted list of namespace/name of Ingresses which win the conflict with the Ingress.
+func (r *ingressProgramResult) conflicts() []string {
+	var winners []string
+	for k := range r.winners {
+		winners = append(winners, k)
+	}
+	sort.Strings(winners)
+	return winners
+}
+
+// succeed records that a rule is programmed.
+func (r *ingressProgramResult) succeed() {
+	r.rules++
+	r.programmed++
+}
+
+// state returns the state of the Ingress and its reason.
+func (r *ingressProgramResult) state() (ingressState, string) {
+	switch {
+	case r.rejectReason != "":
+		return ingressStateRejected, r.rejectReason
+	case r.rules > 0 && r.programmed == 0:
+		return ingressStateRejected, strings.Join(r.problems, "; ")
+	case len(r.problems) > 0:
+		return ingressStateDegraded, strings.Join(r.problems, "; ")
+	default:
+		return ingressStateProgrammed, ""
+	}
+}
+
+// upstreamRevision is the routing relevant part of nghttpx.Upstream which configuration revision is computed from.  It excludes
+// backend addresses, so that endpoint changes, such as Pod rollout, health check and outlier ejection, do not change the revision.
+type upstreamRevision struct {
+	Name             string
+	Host             string
+	Path             string
+	RedirectIfNotTLS bool
+	// BackendSettings is the list of distinct backend connection settings.
+	BackendSettings []nghttpx.UpstreamServer
+}
+
+// newUpstreamRevisions returns upstreamRevision for each of upstreams.
+func newUpstreamRevisions(upstreams []*nghttpx.Upstream) []upstreamRevision {
+	revs := make([]upstreamRevision, len(upstreams))
+	for i, ups := range upstreams {
+		rev := &revs[i]
+		rev.Name = ups.Name
+		rev.Host = ups.Host
+		rev.Path = ups.Path
+		rev.RedirectIfNotTLS = ups.RedirectIfNotTLS
+
+		seen := make(map[nghttpx.UpstreamServer]bool)
+		for _, backend := range ups.Backends {
+			backend.Address = ""
+			backend.Port = ""
+			backend.Zone = ""
+			if seen[backend] {
+				continue
+			}
+			seen[backend] = true
+			rev.BackendSettings = append(rev.BackendSettings, backend)
+		}
+	}
+	return revs
+}
+
+// recordIngressStatuses records the status of each Ingress after the configuration generated from them is applied to nghttpx
+// successfully.  The statuses are written to Ingress annotation later by updateIngressStatusAnnotations.
+func (lbc *LoadBalancerController) recordIngressStatuses(results map[string]*ingressProgramResult, upstreams []*nghttpx.Upstream) {
+	now := time.Now()
+
+	upstreamsBySource := make(map[string][]*nghttpx.Upstream)
+	for _, ups := range upstreams {
+		if ups.Source == "" {
+			continue
+		}
+		upstreamsBySource[ups.Source] = append(upstreamsBySource[ups.Source], ups)
+	}
+
+	statuses := make(map[string]ingressStatus)
+	for key, res := range results {
+		state, reason := res.state()
+		st := ingressStatus{
+			State:           state,
+			Reason:          reason,
+			Conflicts:       res.conflicts(),
+			LastAppliedTime: now,
+		}
+		if ups := upstreamsBySource[key]; len(ups) > 0 {
+			b, err := json.Marshal(newUpstreamRevisions(ups))
+			if err != nil {
+				glog.Errorf("Could not serialize upstreams of Ingress %v: %v", key, err)
+			} else {
+				st.ConfigRevision = nghttpx.Checksum(b)[:configRevisionLength]
+			}
+		}
+		statuses[key] = st
+	}
+
+	lbc.ingStatusMu.Lock()
+	defer lbc.ingStatusMu.Unlock()
+
+	lbc.ingStatuses = statuses
+}
+
+// updateIngressStatusAnnotations writes the recorded status of each Ingress to its annotation if it has changed.  Only the leader
+// writes them.  When an Ingress newly becomes degraded or rejected, or its reason changes, a Warning Event is also recorded.  If the
+// status annotation is disabled, only Events are recorded, and the status last reported is remembered in memory instead.
+func (lbc *LoadBalancerController) updateIngressStatusAnnotations() {
+	if !lbc.leaderElector.IsLeader() {
+		return
+	}
+
+	lbc.ingStatusMu.Lock()
+	statuses := lbc.ingStatuses
+	lbc.ingStatusMu.Unlock()
+
+	if len(statuses) == 0 {
+		return
+	}
+
+	ings, err := lbc.ingLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Could not list Ingress: %v", err)
+		return
+	}
+
+	reported := make(map[string]ingressStatus)
+
+	for _, ing := range ings {
+		select {
+		case <-lbc.shutdownCh:
+			return
+		default:
+		}
+
+		key := fmt.Sprintf("%v/%v", ing.Namespace, ing.Name)
+		st, ok := statuses[key]
+		if !ok || !lbc.ingressManaged(ing) {
+			continue
+		}
+
+		var cur ingressStatus
+		if lbc.ingStatusAnnotation {
+			if s, ok := ing.Annotations[statusKey]; ok {
+				if err := json.Unmarshal([]byte(s), &cur); err == nil && cur.equivalent(st) {
+					continue
+				}
+			}
+
+			if err := lbc.updateIngressStatusAnnotation(ing, st); err != nil {
+				glog.Errorf("Could not update status annotation of Ingress %v/%v: %v", ing.Namespace, ing.Name, err)
+				continue
+			}
+		} else {
+			cur = lbc.reportedIngStatuses[key]
+			reported[key] = st
+		}
+
+		if st.State != ingressStateProgrammed && (st.State != cur.State || st.Reason != cur.Reason) {
+			lbc.recorder.Eventf(ing, v1.EventTypeWarning, string(st.State), "%v", st.Reason)
+		}
+	}
+
+	if !lbc.ingStatusAnnotation {
+		lbc.reportedIngStatuses = reported
+	}
+}
+
+// updateIngressStatusAnnotation writes st to the status annotation of ing.  Writes are rate limited.
+func (lbc *LoadBalancerController) updateIngressStatusAnnotation(ing *extensions.Ingress, st ingressStatus) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	lbc.ingStatusRateLimiter.Accept()
+
+	glog.V(4).Infof("Update status annotation of Ingress %v/%v: %v", ing.Namespace, ing.Name, string(b))
+
+	newIng := *ing
+	newIng.Annotations = copyStringMap(ing.Annotations)
+	newIng.Annotations[statusKey] = string(b)
+
+	_, err = lbc.clientset.ExtensionsV1beta1().Ingresses(ing.Namespace).Update(&newIng)
+	return err
+}
@@ -0,0 +1,201 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// leaderKey is a key to annotation of ConfigMap which stores leader election record.
+	leaderKey = "ingress.zlab.co.jp/leader"
+	// defaultLeaseDuration is the duration that non-leader candidates will wait to force acquire leadership.
+	defaultLeaseDuration = 15 * time.Second
+	// defaultRetryPeriod is the duration that candidates wait between tries of acquiring or renewing leadership.
+	defaultRetryPeriod = 5 * time.Second
+)
+
+// leaderElectionRecord is the record stored in ConfigMap annotation.
+type leaderElectionRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// leaderElector elects a leader among controller replicas using ConfigMap as a lock.  client-go we use does not provide leader
+// election, so that this is a minimal implementation of it.
+type leaderElector struct {
+	clientset clientset.Interface
+	// namespace and name of ConfigMap which is used as a lock.
+	namespace string
+	name      string
+	// identity is the unique identity of this candidate.
+	identity      string
+	leaseDuration time.Duration
+	retryPeriod   time.Duration
+
+	mu sync.Mutex
+	// leader is true if this candidate is a leader.
+	leader bool
+	// observedRecord is the last observed leader election record.
+	observedRecord leaderElectionRecord
+	// observedTime is the time when observedRecord was last changed.  We use local clock to avoid the effect of clock skew.
+	observedTime time.Time
+}
+
+// newLeaderElector returns new leaderElector which uses ConfigMap denoted by namespace and name as a lock.
+func newLeaderElector(clientset clientset.Interface, namespace, name, identity string) *leaderElector {
+	return &leaderElector{
+		clientset:     clientset,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: defaultLeaseDuration,
+		retryPeriod:   defaultRetryPeriod,
+	}
+}
+
+// Run tries to acquire or renew leadership periodically until stopCh is closed.
+func (le *leaderElector) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		leader, err := le.tryAcquireOrRenew()
+		if err != nil {
+			glog.Errorf("Could not acquire or renew leadership: %v", err)
+		}
+		le.setLeader(leader)
+	}, le.retryPeriod, stopCh)
+
+	le.setLeader(false)
+}
+
+// IsLeader returns true if this candidate is a leader.
+func (le *leaderElector) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.leader
+}
+
+func (le *leaderElector) setLeader(leader bool) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.leader != leader {
+		if leader {
+			glog.Infof("Became a leader: %v", le.identity)
+		} else {
+			glog.Infof("Lost leadership: %v", le.identity)
+		}
+	}
+	le.leader = leader
+}
+
+// tryAcquireOrRenew tries to acquire or renew leadership.  It returns true if this candidate is a leader.
+func (le *leaderElector) tryAcquireOrRenew() (bool, error) {
+	now := time.Now()
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	cm, err := le.clientset.CoreV1().ConfigMaps(le.namespace).Get(le.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      le.name,
+				Namespace: le.namespace,
+				Annotations: map[string]string{
+					leaderKey: string(b),
+				},
+			},
+		}
+		if _, err := le.clientset.CoreV1().ConfigMaps(le.namespace).Create(cm); err != nil {
+			return false, fmt.Errorf("Could not create ConfigMap %v/%v: %v", le.namespace, le.name, err)
+		}
+		le.observe(record, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Could not get ConfigMap %v/%v: %v", le.namespace, le.name, err)
+	}
+
+	var oldRecord leaderElectionRecord
+	if data, ok := cm.Annotations[leaderKey]; ok {
+		if err := json.Unmarshal([]byte(data), &oldRecord); err != nil {
+			glog.Errorf("Could not parse %v annotation of ConfigMap %v/%v: %v", leaderKey, le.namespace, le.name, err)
+		}
+	}
+
+	le.mu.Lock()
+	if !reflect.DeepEqual(le.observedRecord, oldRecord) {
+		le.observedRecord = oldRecord
+		le.observedTime = now
+	}
+	observedTime := le.observedTime
+	le.mu.Unlock()
+
+	if oldRecord.HolderIdentity != "" && oldRecord.HolderIdentity != le.identity &&
+		observedTime.Add(time.Duration(oldRecord.LeaseDurationSeconds)*time.Second).After(now) {
+		return false, nil
+	}
+
+	if oldRecord.HolderIdentity == le.identity {
+		record.AcquireTime = oldRecord.AcquireTime
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	// Update fails if someone else has updated ConfigMap since we got it, because cm has resourceVersion.
+	cm.Annotations = copyStringMap(cm.Annotations)
+	cm.Annotations[leaderKey] = string(b)
+	if _, err := le.clientset.CoreV1().ConfigMaps(le.namespace).Update(cm); err != nil {
+		return false, fmt.Errorf("Could not update ConfigMap %v/%v: %v", le.namespace, le.name, err)
+	}
+
+	le.observe(record, now)
+
+	return true, nil
+}
+
+// observe records record as the last observed leader election record.
+func (le *leaderElector) observe(record leaderElectionRecord, now time.Time) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	le.observedRecord = record
+	le.observedTime = now
+}
+
+// copyStringMap returns a copy of m.  It never returns nil.
+func copyStringMap(m map[string]string) map[string]string {
+	dst := make(map[string]string, len(m))
+	for k, v := range m {
+		dst[k] = v
+	}
+	return dst
+}
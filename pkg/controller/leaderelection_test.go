@@ -0,0 +1,51 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestLeaderElectorTryAcquireOrRenew verifies that only one candidate becomes a leader, and the leader can renew its leadership.
+func TestLeaderElectorTryAcquireOrRenew(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	le1 := newLeaderElector(clientset, "kube-system", "leader", "alpha")
+	le2 := newLeaderElector(clientset, "kube-system", "leader", "bravo")
+
+	if leader, err := le1.tryAcquireOrRenew(); err != nil || !leader {
+		t.Fatalf("le1.tryAcquireOrRenew() = %v, %v, want true, nil", leader, err)
+	}
+
+	if leader, err := le2.tryAcquireOrRenew(); err != nil || leader {
+		t.Errorf("le2.tryAcquireOrRenew() = %v, %v, want false, nil", leader, err)
+	}
+
+	if leader, err := le1.tryAcquireOrRenew(); err != nil || !leader {
+		t.Errorf("le1.tryAcquireOrRenew() = %v, %v, want true, nil", leader, err)
+	}
+
+	// Simulate that time has passed since le2 observed the record.
+	le2.observedTime = le2.observedTime.Add(-2 * defaultLeaseDuration)
+
+	if leader, err := le2.tryAcquireOrRenew(); err != nil {
+		t.Fatalf("le2.tryAcquireOrRenew(): %v", err)
+	} else if leader {
+		// The record has been renewed by le1 since le2 observed it.  le2 must not become a leader yet.
+		t.Errorf("le2.tryAcquireOrRenew() = true, want false")
+	}
+
+	// Simulate that le1 has gone, and its lease has expired.
+	le2.observedTime = le2.observedTime.Add(-2 * defaultLeaseDuration)
+
+	if leader, err := le2.tryAcquireOrRenew(); err != nil || !leader {
+		t.Errorf("le2.tryAcquireOrRenew() = %v, %v, want true, nil", leader, err)
+	}
+}
@@ -117,3 +117,18 @@ func newNodeLister(indexer cache.Indexer) *nodeLister {
 		NodeLister: corelisters.NewNodeLister(indexer),
 	}
 }
+
+// namespaceLister makes a Store that lists Namespaces.
+type namespaceLister struct {
+	// indexer is added here so that object can be added to indexer in test.
+	indexer cache.Indexer
+	corelisters.NamespaceLister
+}
+
+// newNamespaceLister creates new namespaceLister.
+func newNamespaceLister(indexer cache.Indexer) *namespaceLister {
+	return &namespaceLister{
+		indexer:         indexer,
+		NamespaceLister: corelisters.NewNamespaceLister(indexer),
+	}
+}
@@ -0,0 +1,365 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+const (
+	// maxAccessLogLineLength is the maximum length of an access log line which outlierDetector buffers.  The longer line is discarded.
+	maxAccessLogLineLength = 64 * 1024
+)
+
+var (
+	// outlierEjections is the total number of ejections of backend endpoints.
+	outlierEjections = expvar.NewInt("outlierEjections")
+	// outlierEjectedEndpoints is the number of backend endpoints which are currently ejected.
+	outlierEjectedEndpoints = expvar.NewInt("outlierEjectedEndpoints")
+)
+
+// OutlierDetectionConfig is the configuration of passive outlier detection.
+type OutlierDetectionConfig struct {
+	// Window is the duration of sliding window over which the error rate of an endpoint is computed.
+	Window time.Duration
+	// ErrorRate is the ratio of 5xx responses in [0, 1] at or above which an endpoint is ejected.
+	ErrorRate float64
+	// MinRequests is the minimum number of requests in Window before an endpoint is considered for ejection.
+	MinRequests int
+	// EjectionDuration is the duration that an endpoint is ejected.
+	EjectionDuration time.Duration
+	// MaxEjectionPercent is the maximum percentage of endpoints of a Service port which can be ejected at the same time.
+	MaxEjectionPercent int
+}
+
+// outlierEndpoint is a backend endpoint observed by outlierDetector.
+type outlierEndpoint struct {
+	Address string
+	Port    string
+}
+
+// outlierBucket counts requests in a second.
+type outlierBucket struct {
+	// second is the Unix time of this bucket.
+	second   int64
+	requests int
+	errors   int
+}
+
+// outlierState is the state of outlierEndpoint.
+type outlierState struct {
+	// buckets is the request counts in the sliding window, ordered by time.
+	buckets []outlierBucket
+	// ejectedUntil is the time when ejection ends.  It is zero if the endpoint is not ejected.
+	ejectedUntil time.Time
+	// generation is the generation of outlierDetector when this endpoint was last used.
+	generation int
+}
+
+// outlierGroup is the set of endpoints of a Service port.  MaxEjectionPercent applies to each group.
+type outlierGroup struct {
+	svc        *v1.Service
+	endpoints  []outlierEndpoint
+	generation int
+}
+
+// outlierDetector reads nghttpx access log, and ejects the backend endpoints which return too many 5xx responses.  Ejected endpoints are
+// removed from backend configuration for EjectionDuration.  It implements io.Writer to receive access log.
+type outlierDetector struct {
+	config OutlierDetectionConfig
+	// recorder records ejection Events on Service.
+	recorder record.EventRecorder
+	// onChange is called when an endpoint is ejected or restored.
+	onChange func()
+	// now returns the current time.
+	now func() time.Time
+
+	mu sync.Mutex
+	// buf is an incomplete access log line.
+	buf []byte
+	// discard is true if the current line is too long, and discarded.
+	discard bool
+	// endpoints is the endpoints which are being observed.
+	endpoints map[outlierEndpoint]*outlierState
+	// groups is the endpoints of Service port keyed by its name.
+	groups map[string]*outlierGroup
+	// generation is incremented each time prune is called.
+	generation int
+}
+
+// newOutlierDetector returns new outlierDetector.
+func newOutlierDetector(config OutlierDetectionConfig, recorder record.EventRecorder, onChange func()) *outlierDetector {
+	return &outlierDetector{
+		config:    config,
+		recorder:  recorder,
+		onChange:  onChange,
+		now:       time.Now,
+		endpoints: make(map[outlierEndpoint]*outlierState),
+		groups:    make(map[string]*outlierGroup),
+	}
+}
+
+// Write parses access log lines in p, and records the responses of observed endpoints.
+func (od *outlierDetector) Write(p []byte) (int, error) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	n := len(p)
+	now := od.now()
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			if !od.discard {
+				od.buf = append(od.buf, p...)
+				if len(od.buf) > maxAccessLogLineLength {
+					od.buf = od.buf[:0]
+					od.discard = true
+				}
+			}
+			break
+		}
+
+		if !od.discard {
+			od.buf = append(od.buf, p[:i]...)
+			od.record(string(od.buf), now)
+		}
+		od.buf = od.buf[:0]
+		od.discard = false
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// record records the response in access log line.
+func (od *outlierDetector) record(line string, now time.Time) {
+	address, port, status, ok := nghttpx.ParseUpstreamAccessLog(line)
+	if !ok {
+		return
+	}
+
+	st, ok := od.endpoints[outlierEndpoint{Address: address, Port: port}]
+	if !ok || !st.ejectedUntil.IsZero() {
+		return
+	}
+
+	sec := now.Unix()
+	if len(st.buckets) == 0 || st.buckets[len(st.buckets)-1].second != sec {
+		st.buckets = append(st.buckets, outlierBucket{second: sec})
+	}
+
+	b := &st.buckets[len(st.buckets)-1]
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+// filter returns the backends of the Service port denoted by name which are not ejected.  It starts observing the backends which are not
+// observed yet.  If all backends are ejected, it returns backends as is, so that an upstream never becomes empty.
+func (od *outlierDetector) filter(name string, svc *v1.Service, backends []nghttpx.UpstreamServer) []nghttpx.UpstreamServer {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	g := &outlierGroup{
+		svc:        svc,
+		generation: od.generation,
+	}
+	od.groups[name] = g
+
+	var active []nghttpx.UpstreamServer
+	for _, backend := range backends {
+		ep := outlierEndpoint{Address: backend.Address, Port: backend.Port}
+		g.endpoints = append(g.endpoints, ep)
+
+		st, ok := od.endpoints[ep]
+		if !ok {
+			st = &outlierState{}
+			od.endpoints[ep] = st
+		}
+		st.generation = od.generation
+
+		if st.ejectedUntil.IsZero() {
+			active = append(active, backend)
+		}
+	}
+
+	if len(active) == 0 && len(backends) > 0 {
+		glog.Warningf("All backends of %v are ejected; use all of them", name)
+		return backends
+	}
+
+	return active
+}
+
+// prune stops observing the endpoints which have not been passed to filter since the last call of prune.
+func (od *outlierDetector) prune() {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	for name, g := range od.groups {
+		if g.generation != od.generation {
+			delete(od.groups, name)
+		}
+	}
+
+	for ep, st := range od.endpoints {
+		if st.generation != od.generation {
+			glog.V(4).Infof("Stop observing backend %v:%v", ep.Address, ep.Port)
+			delete(od.endpoints, ep)
+		}
+	}
+
+	od.updateEjectedEndpoints()
+
+	od.generation++
+}
+
+// evaluate ejects the endpoints whose error rate exceeds the threshold, and restores the endpoints whose ejection has expired.  It calls
+// onChange if any endpoint is ejected or restored.
+func (od *outlierDetector) evaluate() {
+	if od.update() {
+		od.onChange()
+	}
+}
+
+// update is the body of evaluate, and returns true if any endpoint is ejected or restored.
+func (od *outlierDetector) update() bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	now := od.now()
+	windowStart := now.Add(-od.config.Window).Unix()
+
+	eps := make([]outlierEndpoint, 0, len(od.endpoints))
+	for ep := range od.endpoints {
+		eps = append(eps, ep)
+	}
+	// Sort endpoints, so that the endpoints are ejected in deterministic order when MaxEjectionPercent is reached.
+	sort.Slice(eps, func(i, j int) bool {
+		if eps[i].Address != eps[j].Address {
+			return eps[i].Address < eps[j].Address
+		}
+		return eps[i].Port < eps[j].Port
+	})
+
+	changed := false
+
+	for _, ep := range eps {
+		st := od.endpoints[ep]
+
+		if !st.ejectedUntil.IsZero() {
+			if now.Before(st.ejectedUntil) {
+				continue
+			}
+			glog.Infof("Backend %v:%v is restored", ep.Address, ep.Port)
+			st.ejectedUntil = time.Time{}
+			od.recordEvent(ep, v1.EventTypeNormal, "BackendRestored", "Backend %v:%v is restored after ejection", ep.Address, ep.Port)
+			changed = true
+			continue
+		}
+
+		for len(st.buckets) > 0 && st.buckets[0].second <= windowStart {
+			st.buckets = st.buckets[1:]
+		}
+
+		var requests, errors int
+		for _, b := range st.buckets {
+			requests += b.requests
+			errors += b.errors
+		}
+
+		if requests == 0 || requests < od.config.MinRequests || float64(errors)/float64(requests) < od.config.ErrorRate {
+			continue
+		}
+
+		if !od.canEject(ep) {
+			glog.V(4).Infof("Backend %v:%v is not ejected because the maximum ejection percent is reached", ep.Address, ep.Port)
+			continue
+		}
+
+		glog.Warningf("Backend %v:%v is ejected: %v errors in %v requests", ep.Address, ep.Port, errors, requests)
+		st.ejectedUntil = now.Add(od.config.EjectionDuration)
+		st.buckets = nil
+		outlierEjections.Add(1)
+		od.recordEvent(ep, v1.EventTypeWarning, "BackendEjected", "Backend %v:%v is ejected for %v: %v errors in %v requests",
+			ep.Address, ep.Port, od.config.EjectionDuration, errors, requests)
+		changed = true
+	}
+
+	od.updateEjectedEndpoints()
+
+	return changed
+}
+
+// canEject returns true if ejecting ep does not exceed MaxEjectionPercent in any group which ep belongs to.
+func (od *outlierDetector) canEject(ep outlierEndpoint) bool {
+	for _, g := range od.groups {
+		if !g.contains(ep) {
+			continue
+		}
+
+		ejected := 0
+		for _, e := range g.endpoints {
+			if st, ok := od.endpoints[e]; ok && !st.ejectedUntil.IsZero() {
+				ejected++
+			}
+		}
+
+		if (ejected+1)*100 > len(g.endpoints)*od.config.MaxEjectionPercent {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordEvent records Event on the Services which ep belongs to.
+func (od *outlierDetector) recordEvent(ep outlierEndpoint, eventType, reason, messageFmt string, args ...interface{}) {
+	// A Service might be referenced from several groups through its ports.
+	seen := make(map[*v1.Service]bool)
+	for _, g := range od.groups {
+		if g.svc == nil || seen[g.svc] || !g.contains(ep) {
+			continue
+		}
+		seen[g.svc] = true
+		od.recorder.Eventf(g.svc, eventType, reason, messageFmt, args...)
+	}
+}
+
+// updateEjectedEndpoints updates outlierEjectedEndpoints metric.
+func (od *outlierDetector) updateEjectedEndpoints() {
+	var n int64
+	for _, st := range od.endpoints {
+		if !st.ejectedUntil.IsZero() {
+			n++
+		}
+	}
+	outlierEjectedEndpoints.Set(n)
+}
+
+// contains returns true if g contains ep.
+func (g *outlierGroup) contains(ep outlierEndpoint) bool {
+	for _, e := range g.endpoints {
+		if e == ep {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+// newTestOutlierDetector returns outlierDetector whose clock is controlled by the returned pointer.
+func newTestOutlierDetector(config OutlierDetectionConfig) (*outlierDetector, *record.FakeRecorder, *time.Time) {
+	recorder := record.NewFakeRecorder(100)
+	od := newOutlierDetector(config, recorder, func() {})
+	now := time.Date(2017, 10, 18, 10, 0, 0, 0, time.UTC)
+	od.now = func() time.Time { return now }
+	return od, recorder, &now
+}
+
+// writeAccessLog writes n access log lines of backend with status to od.
+func writeAccessLog(od *outlierDetector, backend nghttpx.UpstreamServer, status, n int) {
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(od, "127.0.0.1 \"GET / HTTP/2\" %v upstream=%v:%v status=%v\n", status, backend.Address, backend.Port, status)
+	}
+}
+
+// TestOutlierDetectorWrite verifies that Write handles access log lines which are split across writes.
+func TestOutlierDetectorWrite(t *testing.T) {
+	od, _, _ := newTestOutlierDetector(OutlierDetectionConfig{Window: time.Minute})
+	backend := nghttpx.UpstreamServer{Address: "192.168.10.1", Port: "80"}
+	od.filter("default/alpha,80", nil, []nghttpx.UpstreamServer{backend})
+
+	for _, s := range []string{
+		"\"GET / HTTP/2\" 503 upstream=192.168.",
+		"10.1:80 status=503\n\"GET / HTTP/2\" 200 upstream=192.168.10.1:80 status=200\n",
+		// Not observed endpoint is ignored.
+		"\"GET / HTTP/2\" 200 upstream=192.168.10.2:80 status=200\n\"GET / HTTP/2\" 200",
+	} {
+		if _, err := od.Write([]byte(s)); err != nil {
+			t.Fatalf("od.Write(%q): %v", s, err)
+		}
+	}
+
+	st := od.endpoints[outlierEndpoint{Address: backend.Address, Port: backend.Port}]
+	if got, want := len(st.buckets), 1; got != want {
+		t.Fatalf("len(st.buckets) = %v, want %v", got, want)
+	}
+	if got, want := st.buckets[0].requests, 2; got != want {
+		t.Errorf("st.buckets[0].requests = %v, want %v", got, want)
+	}
+	if got, want := st.buckets[0].errors, 1; got != want {
+		t.Errorf("st.buckets[0].errors = %v, want %v", got, want)
+	}
+	if got, want := len(od.endpoints), 1; got != want {
+		t.Errorf("len(od.endpoints) = %v, want %v", got, want)
+	}
+}
+
+// TestOutlierDetectorEjectAndRestore verifies that an endpoint with high error rate is ejected, and restored after EjectionDuration.
+func TestOutlierDetectorEjectAndRestore(t *testing.T) {
+	od, recorder, now := newTestOutlierDetector(OutlierDetectionConfig{
+		Window:             10 * time.Second,
+		ErrorRate:          0.5,
+		MinRequests:        10,
+		EjectionDuration:   30 * time.Second,
+		MaxEjectionPercent: 50,
+	})
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "alpha", Namespace: "default"}}
+	backends := []nghttpx.UpstreamServer{
+		{Address: "192.168.10.1", Port: "80"},
+		{Address: "192.168.10.2", Port: "80"},
+	}
+	od.filter("default/alpha,80", svc, backends)
+
+	// Too few requests.
+	writeAccessLog(od, backends[0], 503, 9)
+	if od.update() {
+		t.Fatalf("od.update() = true, want false")
+	}
+
+	// Errors outside the window are forgotten.
+	*now = now.Add(20 * time.Second)
+	writeAccessLog(od, backends[0], 503, 5)
+	writeAccessLog(od, backends[0], 200, 5)
+	writeAccessLog(od, backends[1], 200, 10)
+	if !od.update() {
+		t.Fatalf("od.update() = false, want true")
+	}
+
+	if got, want := od.filter("default/alpha,80", svc, backends), backends[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("od.filter(...) = %+v, want %+v", got, want)
+	}
+	if got, want := len(recorder.Events), 1; got != want {
+		t.Errorf("len(recorder.Events) = %v, want %v", got, want)
+	} else if e := <-recorder.Events; e != "Warning BackendEjected Backend 192.168.10.1:80 is ejected for 30s: 5 errors in 10 requests" {
+		t.Errorf("Event = %q", e)
+	}
+
+	*now = now.Add(29 * time.Second)
+	if od.update() {
+		t.Fatalf("od.update() = true, want false")
+	}
+
+	*now = now.Add(time.Second)
+	if !od.update() {
+		t.Fatalf("od.update() = false, want true")
+	}
+
+	if got, want := od.filter("default/alpha,80", svc, backends), backends; !reflect.DeepEqual(got, want) {
+		t.Errorf("od.filter(...) = %+v, want %+v", got, want)
+	}
+	if got, want := len(recorder.Events), 1; got != want {
+		t.Errorf("len(recorder.Events) = %v, want %v", got, want)
+	} else if e := <-recorder.Events; e != "Normal BackendRestored Backend 192.168.10.1:80 is restored after ejection" {
+		t.Errorf("Event = %q", e)
+	}
+}
+
+// TestOutlierDetectorMaxEjectionPercent verifies that endpoints are not ejected beyond MaxEjectionPercent.
+func TestOutlierDetectorMaxEjectionPercent(t *testing.T) {
+	od, _, _ := newTestOutlierDetector(OutlierDetectionConfig{
+		Window:             10 * time.Second,
+		ErrorRate:          0.5,
+		MinRequests:        1,
+		EjectionDuration:   30 * time.Second,
+		MaxEjectionPercent: 50,
+	})
+	backends := []nghttpx.UpstreamServer{
+		{Address: "192.168.10.1", Port: "80"},
+		{Address: "192.168.10.2", Port: "80"},
+		{Address: "192.168.10.3", Port: "80"},
+		{Address: "192.168.10.4", Port: "80"},
+	}
+	od.filter("default/alpha,80", nil, backends)
+	// The last endpoint is the only endpoint of another Service port.  It cannot be ejected because that would empty the port.
+	od.filter("default/bravo,80", nil, backends[3:])
+
+	for _, backend := range backends {
+		writeAccessLog(od, backend, 502, 1)
+	}
+	if !od.update() {
+		t.Fatalf("od.update() = false, want true")
+	}
+
+	if got, want := od.filter("default/alpha,80", nil, backends), backends[2:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("od.filter(...) = %+v, want %+v", got, want)
+	}
+
+	od.prune()
+	od.filter("default/alpha,80", nil, backends[:1])
+	od.prune()
+
+	if got, want := len(od.endpoints), 1; got != want {
+		t.Errorf("len(od.endpoints) = %v, want %v", got, want)
+	}
+	if got, want := len(od.groups), 1; got != want {
+		t.Errorf("len(od.groups) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+// zoneLabelKeys is the list of Node label keys which contain zone, in the order of preference.
+var zoneLabelKeys = []string{
+	"topology.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/zone",
+}
+
+// TopologyConfig is the configuration of zone-aware routing.
+type TopologyConfig struct {
+	// MinLocalEndpoints is the minimum number of healthy endpoints in the local zone.  If the local zone has fewer healthy endpoints,
+	// endpoints in all zones are used.
+	MinLocalEndpoints int
+	// MinLocalHealthyFraction is the minimum ratio in [0, 1] of healthy endpoints to all endpoints in the local zone.  If the ratio is
+	// lower, endpoints in all zones are used.
+	MinLocalHealthyFraction float64
+}
+
+// nodeZone returns the zone of node.  It returns empty string if node has no zone label.
+func nodeZone(node *v1.Node) string {
+	for _, key := range zoneLabelKeys {
+		if zone := node.Labels[key]; zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
+
+// getNodeZone returns the zone of Node named nodeName.  It returns empty string if the zone is unknown.
+func (lbc *LoadBalancerController) getNodeZone(nodeName string) string {
+	node, err := lbc.nodeLister.Get(nodeName)
+	if err != nil {
+		glog.V(4).Infof("Could not get Node %v from lister: %v", nodeName, err)
+		return ""
+	}
+	return nodeZone(node)
+}
+
+// getLocalZone returns the zone of Node where this controller runs.  It returns empty string if the zone is unknown.
+func (lbc *LoadBalancerController) getLocalZone() string {
+	pod, err := lbc.getThisPod()
+	if err != nil {
+		glog.V(3).Info(err)
+		return ""
+	}
+	if pod.Spec.NodeName == "" {
+		return ""
+	}
+	return lbc.getNodeZone(pod.Spec.NodeName)
+}
+
+// preferLocalZone returns the endpoints in healthy which are in the local zone of this controller.  all is the list of endpoints
+// before unhealthy ones are removed.  If the local zone is unknown, or it does not have enough healthy endpoints, it returns healthy.
+// key identifies a Service port, and is only used for logging.
+func (lbc *LoadBalancerController) preferLocalZone(key string, all, healthy []nghttpx.UpstreamServer) []nghttpx.UpstreamServer {
+	zone := lbc.getLocalZone()
+	if zone == "" {
+		glog.V(4).Infof("Zone of this controller is unknown; use endpoints in all zones for %v", key)
+		return healthy
+	}
+
+	return filterLocalZone(zone, all, healthy, lbc.topology)
+}
+
+// filterLocalZone returns the endpoints in healthy which are in zone.  If zone does not have enough healthy endpoints in terms of
+// config, it returns healthy.
+func filterLocalZone(zone string, all, healthy []nghttpx.UpstreamServer, config *TopologyConfig) []nghttpx.UpstreamServer {
+	var localTotal int
+	for i := range all {
+		if all[i].Zone == zone {
+			localTotal++
+		}
+	}
+
+	var local []nghttpx.UpstreamServer
+	for i := range healthy {
+		if healthy[i].Zone == zone {
+			local = append(local, healthy[i])
+		}
+	}
+
+	if len(local) == 0 || len(local) < config.MinLocalEndpoints ||
+		float64(len(local)) < config.MinLocalHealthyFraction*float64(localTotal) {
+		glog.V(4).Infof("Zone %v has %v healthy endpoints out of %v; use endpoints in all zones", zone, len(local), localTotal)
+		return healthy
+	}
+
+	return local
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zlabjp/nghttpx-ingress-lb/pkg/nghttpx"
+)
+
+// TestFilterLocalZone verifies filterLocalZone.
+func TestFilterLocalZone(t *testing.T) {
+	all := []nghttpx.UpstreamServer{
+		{Address: "192.168.0.1", Zone: "zone-a"},
+		{Address: "192.168.0.2", Zone: "zone-a"},
+		{Address: "192.168.0.3", Zone: "zone-a"},
+		{Address: "192.168.0.4", Zone: "zone-a"},
+		{Address: "192.168.0.5", Zone: "zone-b"},
+		{Address: "192.168.0.6"},
+	}
+
+	tests := []struct {
+		desc      string
+		zone      string
+		healthy   []nghttpx.UpstreamServer
+		config    TopologyConfig
+		wantAddrs []string
+	}{
+		{
+			desc:      "all local endpoints are healthy",
+			zone:      "zone-a",
+			healthy:   all,
+			config:    TopologyConfig{MinLocalEndpoints: 2, MinLocalHealthyFraction: 0.5},
+			wantAddrs: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3", "192.168.0.4"},
+		},
+		{
+			desc:      "enough local endpoints are healthy",
+			zone:      "zone-a",
+			healthy:   []nghttpx.UpstreamServer{all[0], all[2], all[4], all[5]},
+			config:    TopologyConfig{MinLocalEndpoints: 2, MinLocalHealthyFraction: 0.5},
+			wantAddrs: []string{"192.168.0.1", "192.168.0.3"},
+		},
+		{
+			desc:      "fewer healthy local endpoints than the fraction",
+			zone:      "zone-a",
+			healthy:   []nghttpx.UpstreamServer{all[0], all[4], all[5]},
+			config:    TopologyConfig{MinLocalEndpoints: 1, MinLocalHealthyFraction: 0.5},
+			wantAddrs: []string{"192.168.0.1", "192.168.0.5", "192.168.0.6"},
+		},
+		{
+			desc:      "fewer healthy local endpoints than the number",
+			zone:      "zone-b",
+			healthy:   all,
+			config:    TopologyConfig{MinLocalEndpoints: 2},
+			wantAddrs: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3", "192.168.0.4", "192.168.0.5", "192.168.0.6"},
+		},
+		{
+			desc:      "no local endpoint",
+			zone:      "zone-c",
+			healthy:   all,
+			wantAddrs: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3", "192.168.0.4", "192.168.0.5", "192.168.0.6"},
+		},
+	}
+
+	for _, tt := range tests {
+		var addrs []string
+		for _, ups := range filterLocalZone(tt.zone, all, tt.healthy, &tt.config) {
+			addrs = append(addrs, ups.Address)
+		}
+		if got, want := addrs, tt.wantAddrs; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: filterLocalZone(...) = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+// TestNodeNotification verifies that the addition of Node with zone, and the change of zone of Node trigger sync only if zone-aware
+// routing is enabled.
+func TestNodeNotification(t *testing.T) {
+	newNode := func(zone string) *v1.Node {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node1",
+			},
+		}
+		if zone != "" {
+			node.Labels = map[string]string{zoneLabelKeys[0]: zone}
+		}
+		return node
+	}
+
+	tests := []struct {
+		desc      string
+		topology  bool
+		old, cur  *v1.Node
+		wantQueue int
+	}{
+		{
+			desc:      "add Node with zone",
+			topology:  true,
+			cur:       newNode("zone-a"),
+			wantQueue: 1,
+		},
+		{
+			desc:     "add Node without zone",
+			topology: true,
+			cur:      newNode(""),
+		},
+		{
+			desc:      "zone changed",
+			topology:  true,
+			old:       newNode("zone-a"),
+			cur:       newNode("zone-b"),
+			wantQueue: 1,
+		},
+		{
+			desc:     "zone unchanged",
+			topology: true,
+			old:      newNode("zone-a"),
+			cur:      newNode("zone-a"),
+		},
+		{
+			desc: "zone-aware routing is disabled",
+			old:  newNode("zone-a"),
+			cur:  newNode("zone-b"),
+		},
+	}
+
+	for _, tt := range tests {
+		f := newFixture(t)
+		f.prepare()
+		if tt.topology {
+			f.lbc.topology = &TopologyConfig{}
+		}
+
+		if tt.old == nil {
+			f.lbc.addNodeNotification(tt.cur)
+		} else {
+			f.lbc.updateNodeNotification(tt.old, tt.cur)
+		}
+
+		if got, want := f.lbc.syncQueue.Len(), tt.wantQueue; got != want {
+			t.Errorf("%v: f.lbc.syncQueue.Len() = %v, want %v", tt.desc, got, want)
+		}
+
+		f.lbc.syncQueue.ShutDown()
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
 )
 
 // podInfo contains runtime information about the pod
@@ -122,6 +123,47 @@ func removeAddressFromLoadBalancerIngress(a []v1.LoadBalancerIngress, addr strin
 	return a[:p]
 }
 
+// ingressTLSCoversHost returns true if host is listed in ing.Spec.TLS.  If an item in ing.Spec.TLS has no hosts, it covers all hosts in
+// ing.  Host names are compared case-insensitively.
+func ingressTLSCoversHost(ing *extensions.Ingress, host string) bool {
+	for i, _ := range ing.Spec.TLS {
+		tls := &ing.Spec.TLS[i]
+		if len(tls.Hosts) == 0 {
+			return true
+		}
+		for _, h := range tls.Hosts {
+			if strings.EqualFold(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ingressRuleHosts returns the hosts of the rules in ing.  The rules without host are ignored.
+func ingressRuleHosts(ing *extensions.Ingress) []string {
+	var hosts []string
+	for i, _ := range ing.Spec.Rules {
+		if h := ing.Spec.Rules[i].Host; h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hostMatches returns true if host matches one of the names in hosts.  hosts must be lower-cased.  A wildcard name such as
+// "*.example.com" matches exactly one label in place of "*".
+func hostMatches(hosts map[string]bool, host string) bool {
+	host = strings.ToLower(host)
+	if hosts[host] {
+		return true
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return hosts["*"+host[i:]]
+	}
+	return false
+}
+
 // podFindPort is copied from
 // https://github.com/kubernetes/kubernetes/blob/886e04f1fffbb04faf8a9f9ee141143b2684ae68/pkg/api/v1/pod/util.go#L29 because original
 // FindPort requires k8s.io/kubernetes/pkg/api/v1 while we use k8s.io/client-go/pkg/api/v1.
@@ -148,3 +190,25 @@ func podFindPort(pod *v1.Pod, svcPort *v1.ServicePort) (int, error) {
 
 	return 0, fmt.Errorf("no suitable port for manifest: %s", pod.UID)
 }
+
+// splitNamespaces parses comma separated list of namespaces, and returns them as a set.  If s contains no namespace, it returns nil, which
+// means all namespaces.
+func splitNamespaces(s string) map[string]bool {
+	var namespaces map[string]bool
+	for _, ns := range strings.Split(s, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" {
+			continue
+		}
+		if namespaces == nil {
+			namespaces = make(map[string]bool)
+		}
+		namespaces[ns] = true
+	}
+	return namespaces
+}
+
+// serviceHasClusterIP returns true if svc has ClusterIP.  It returns false for headless Service.
+func serviceHasClusterIP(svc *v1.Service) bool {
+	return svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != v1.ClusterIPNone
+}
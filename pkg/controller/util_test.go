@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
 )
 
 // TestSortLoadBalancerIngress verifies that sortLoadBalancerIngress sorts given items.
@@ -123,3 +124,91 @@ func TestUtilRemoveAddressFromLoadBalancerIngress(t *testing.T) {
 		}
 	}
 }
+
+// TestIngressTLSCoversHost verifies ingressTLSCoversHost.
+func TestIngressTLSCoversHost(t *testing.T) {
+	tests := []struct {
+		tls  []extensions.IngressTLS
+		host string
+		want bool
+	}{
+		{
+			host: "alpha.test",
+			want: false,
+		},
+		{
+			tls:  []extensions.IngressTLS{{SecretName: "tls"}},
+			host: "alpha.test",
+			want: true,
+		},
+		{
+			tls:  []extensions.IngressTLS{{SecretName: "tls", Hosts: []string{"bravo.test"}}, {SecretName: "tls2", Hosts: []string{"Alpha.test"}}},
+			host: "alpha.test",
+			want: true,
+		},
+		{
+			tls:  []extensions.IngressTLS{{SecretName: "tls", Hosts: []string{"bravo.test"}}},
+			host: "alpha.test",
+			want: false,
+		},
+	}
+
+	for i, tt := range tests {
+		ing := &extensions.Ingress{Spec: extensions.IngressSpec{TLS: tt.tls}}
+		if got, want := ingressTLSCoversHost(ing, tt.host), tt.want; got != want {
+			t.Errorf("#%v: ingressTLSCoversHost(%+v, %q) = %v, want %v", i, tt.tls, tt.host, got, want)
+		}
+	}
+}
+
+// TestHostMatches verifies hostMatches.
+func TestHostMatches(t *testing.T) {
+	hosts := map[string]bool{"alpha.test": true, "*.bravo.test": true}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "alpha.test", want: true},
+		{host: "Alpha.Test", want: true},
+		{host: "www.alpha.test", want: false},
+		{host: "www.bravo.test", want: true},
+		{host: "a.www.bravo.test", want: false},
+		{host: "bravo.test", want: false},
+		{host: "charlie.test", want: false},
+	}
+
+	for i, tt := range tests {
+		if got, want := hostMatches(hosts, tt.host), tt.want; got != want {
+			t.Errorf("#%v: hostMatches(%v, %q) = %v, want %v", i, hosts, tt.host, got, want)
+		}
+	}
+}
+
+// TestSplitNamespaces verifies splitNamespaces.
+func TestSplitNamespaces(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]bool
+	}{
+		{
+			in: "",
+		},
+		{
+			in: " , ",
+		},
+		{
+			in:   "alpha",
+			want: map[string]bool{"alpha": true},
+		},
+		{
+			in:   "alpha, bravo,,alpha",
+			want: map[string]bool{"alpha": true, "bravo": true},
+		},
+	}
+
+	for _, tt := range tests {
+		if got, want := splitNamespaces(tt.in), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("splitNamespaces(%q) = %v, want %v", tt.in, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"regexp"
+	"strconv"
+)
+
+const (
+	// DefaultAccessLogFormat is the default access log format of nghttpx.
+	DefaultAccessLogFormat = `$remote_addr - - [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+	// upstreamAccessLogSuffix is appended to access log format when OutlierDetection is enabled, so that the controller can tell which
+	// backend served a request.  $status is the status code sent to the client, which is usually the one returned by backend, but nghttpx
+	// might generate it; for example, 502 when it failed to connect to backend.
+	upstreamAccessLogSuffix = ` upstream=$backend_host:$backend_port status=$status`
+)
+
+// upstreamAccessLogRegexp matches upstreamAccessLogSuffix in an access log line.  backend_host might be an IPv6 address, so that the port
+// is taken from the last colon.
+var upstreamAccessLogRegexp = regexp.MustCompile(` upstream=(\S+):([^:\s]+) status=(\d+)$`)
+
+// EffectiveAccessLogFormat returns the access log format written to nghttpx configuration.  If it is empty, nghttpx default is used.
+func (ic *IngressConfig) EffectiveAccessLogFormat() string {
+	if !ic.OutlierDetection {
+		return ic.AccessLogFormat
+	}
+
+	format := ic.AccessLogFormat
+	if format == "" {
+		format = DefaultAccessLogFormat
+	}
+
+	return format + upstreamAccessLogSuffix
+}
+
+// ParseUpstreamAccessLog parses the upstream information in line, which is an access log line written with EffectiveAccessLogFormat
+// when OutlierDetection is enabled.  It returns the address and port of backend, and the status code sent to the client.  ok is false if
+// line does not contain backend information; for example, the request was not forwarded to backend.
+func ParseUpstreamAccessLog(line string) (address, port string, status int, ok bool) {
+	m := upstreamAccessLogRegexp.FindStringSubmatch(line)
+	if m == nil || m[1] == "-" || m[2] == "-" {
+		return "", "", 0, false
+	}
+
+	status, err := strconv.Atoi(m[3])
+	if err != nil {
+		return "", "", 0, false
+	}
+
+	address = m[1]
+	// nghttpx encloses IPv6 address in square brackets.
+	if len(address) > 2 && address[0] == '[' && address[len(address)-1] == ']' {
+		address = address[1 : len(address)-1]
+	}
+
+	return address, m[2], status, true
+}
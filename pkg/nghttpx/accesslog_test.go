@@ -0,0 +1,106 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"testing"
+)
+
+// TestEffectiveAccessLogFormat verifies EffectiveAccessLogFormat.
+func TestEffectiveAccessLogFormat(t *testing.T) {
+	tests := []struct {
+		format           string
+		outlierDetection bool
+		want             string
+	}{
+		{
+			want: "",
+		},
+		{
+			format: `$remote_addr $status`,
+			want:   `$remote_addr $status`,
+		},
+		{
+			outlierDetection: true,
+			want:             DefaultAccessLogFormat + upstreamAccessLogSuffix,
+		},
+		{
+			format:           `$remote_addr $status`,
+			outlierDetection: true,
+			want:             `$remote_addr $status` + upstreamAccessLogSuffix,
+		},
+	}
+
+	for i, tt := range tests {
+		ingConfig := NewIngressConfig()
+		ingConfig.AccessLogFormat = tt.format
+		ingConfig.OutlierDetection = tt.outlierDetection
+
+		if got, want := ingConfig.EffectiveAccessLogFormat(), tt.want; got != want {
+			t.Errorf("#%v: ingConfig.EffectiveAccessLogFormat() = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestParseUpstreamAccessLog verifies ParseUpstreamAccessLog.
+func TestParseUpstreamAccessLog(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantAddress string
+		wantPort    string
+		wantStatus  int
+		wantOK      bool
+	}{
+		{
+			line:        `127.0.0.1 - - [18/Oct/2017:10:00:00 +0900] "GET / HTTP/2" 503 0 "-" "curl" upstream=192.168.10.1:80 status=503`,
+			wantAddress: "192.168.10.1",
+			wantPort:    "80",
+			wantStatus:  503,
+			wantOK:      true,
+		},
+		{
+			line:        `"GET / HTTP/1.1" 200 upstream=[2001:db8::1]:8080 status=200`,
+			wantAddress: "2001:db8::1",
+			wantPort:    "8080",
+			wantStatus:  200,
+			wantOK:      true,
+		},
+		{
+			line:        `"GET / HTTP/1.1" 200 upstream=2001:db8::1:8080 status=200`,
+			wantAddress: "2001:db8::1",
+			wantPort:    "8080",
+			wantStatus:  200,
+			wantOK:      true,
+		},
+		{
+			// Request was not forwarded to backend.
+			line: `"GET / HTTP/1.1" 404 upstream=-:- status=404`,
+		},
+		{
+			line: `"GET / HTTP/1.1" 200`,
+		},
+		{
+			line: `I1018 10:00:00.000000 1 controller.go:100] upstream=192.168.10.1:80 status=200 trailing`,
+		},
+	}
+
+	for i, tt := range tests {
+		address, port, status, ok := ParseUpstreamAccessLog(tt.line)
+		if ok != tt.wantOK {
+			t.Errorf("#%v: ParseUpstreamAccessLog(%q) ok = %v, want %v", i, tt.line, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if address != tt.wantAddress || port != tt.wantPort || status != tt.wantStatus {
+			t.Errorf("#%v: ParseUpstreamAccessLog(%q) = %q, %q, %v, want %q, %q, %v", i, tt.line, address, port, status,
+				tt.wantAddress, tt.wantPort, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// versionRegexp matches the version string in the output of nghttpx --version.
+	versionRegexp = regexp.MustCompile(`nghttp2/([0-9]+)\.([0-9]+)\.([0-9]+)`)
+	// helpOptionRegexp matches the option name in the output of nghttpx --help.
+	helpOptionRegexp = regexp.MustCompile(`(?m)^\s+(?:-[0-9A-Za-z], )?--([0-9a-z][0-9a-z-]*)`)
+)
+
+// Capabilities describes the features which nghttpx binary supports.
+type Capabilities struct {
+	// Version is the version of nghttpx, such as "1.25.0".  It is empty if the version is unknown.
+	Version string
+	// Options is the set of option names which nghttpx accepts.  It is nil if the options are unknown.
+	Options map[string]bool
+	// CookieAffinity is true if nghttpx supports cookie based session affinity.
+	CookieAffinity bool
+	// MrubyTLSClientCert is true if mruby Env of nghttpx provides the information of client certificate, such as
+	// tls_client_subject_name.
+	MrubyTLSClientCert bool
+	// ProxyProto is true if nghttpx supports proxyproto parameter of frontend option.
+	ProxyProto bool
+	// BackendLogVariables is true if nghttpx supports $backend_host and $backend_port in access log format.
+	BackendLogVariables bool
+	// PerPatternMruby is true if nghttpx supports mruby parameter of backend option.
+	PerPatternMruby bool
+}
+
+// DetectCapabilities runs nghttpx at path with --version and --help, and returns its Capabilities.
+func DetectCapabilities(path string) (*Capabilities, error) {
+	versionOutput, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not run %v --version: %v", path, err)
+	}
+	helpOutput, err := exec.Command(path, "--help").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not run %v --help: %v", path, err)
+	}
+	return NewCapabilities(string(versionOutput), string(helpOutput))
+}
+
+// NewCapabilities returns Capabilities of nghttpx from versionOutput and helpOutput, which are the output of nghttpx --version and
+// nghttpx --help respectively.  If helpOutput is empty, the supported options are unknown, and the features which are found in
+// helpOutput are assumed to be supported.
+func NewCapabilities(versionOutput, helpOutput string) (*Capabilities, error) {
+	m := versionRegexp.FindStringSubmatch(versionOutput)
+	if m == nil {
+		return nil, fmt.Errorf("Could not find version in %q", versionOutput)
+	}
+
+	var ver [3]int
+	for i, _ := range ver {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse version %v: %v", m[0], err)
+		}
+		ver[i] = n
+	}
+
+	atLeast := func(major, minor int) bool {
+		return ver[0] > major || (ver[0] == major && ver[1] >= minor)
+	}
+
+	caps := &Capabilities{
+		Version: fmt.Sprintf("%v.%v.%v", ver[0], ver[1], ver[2]),
+		// Cookie based session affinity was introduced in nghttpx v1.31.0.  This is used only if helpOutput is empty.
+		CookieAffinity: atLeast(1, 31),
+		// env.tls_client_subject_name was introduced in nghttpx v1.22.0.
+		MrubyTLSClientCert:  atLeast(1, 22),
+		ProxyProto:          true,
+		BackendLogVariables: true,
+		PerPatternMruby:     true,
+	}
+
+	if helpOutput != "" {
+		caps.Options = make(map[string]bool)
+		for _, m := range helpOptionRegexp.FindAllStringSubmatch(helpOutput, -1) {
+			caps.Options[m[1]] = true
+		}
+		// The description of frontend and accesslog-format options lists their parameters and variables.
+		caps.ProxyProto = strings.Contains(helpOutput, `"proxyproto"`)
+		caps.BackendLogVariables = strings.Contains(helpOutput, "$backend_host") && strings.Contains(helpOutput, "$backend_port")
+		// The description of backend option lists its parameters.
+		caps.PerPatternMruby = strings.Contains(helpOutput, `"mruby=<PATH>"`)
+		caps.CookieAffinity = strings.Contains(helpOutput, `"affinity-cookie-name=<NAME>"`)
+	}
+
+	return caps, nil
+}
+
+// HasOption returns true if nghttpx accepts the option name.  If the supported options are unknown, it returns true, because the
+// option has been explicitly requested by user, and nghttpx reports the error if it is not supported after all.
+func (caps *Capabilities) HasOption(name string) bool {
+	if caps.Options == nil {
+		return true
+	}
+	return caps.Options[name]
+}
+
+// String returns the human readable representation of caps.
+func (caps *Capabilities) String() string {
+	if caps.Version == "" {
+		return "nghttpx: unknown"
+	}
+
+	var features []string
+	if caps.CookieAffinity {
+		features = append(features, "cookie-affinity")
+	}
+	if caps.MrubyTLSClientCert {
+		features = append(features, "mruby-tls-client-cert")
+	}
+	if caps.ProxyProto {
+		features = append(features, "proxyproto")
+	}
+	if caps.BackendLogVariables {
+		features = append(features, "backend-log-variables")
+	}
+	if caps.PerPatternMruby {
+		features = append(features, "per-pattern-mruby")
+	}
+	s := fmt.Sprintf("nghttpx: %v\nfeatures: %v", caps.Version, strings.Join(features, ","))
+
+	if caps.Options != nil {
+		opts := make([]string, 0, len(caps.Options))
+		for name := range caps.Options {
+			opts = append(opts, name)
+		}
+		sort.Strings(opts)
+		s += fmt.Sprintf("\noptions: %v", strings.Join(opts, ","))
+	}
+
+	return s
+}
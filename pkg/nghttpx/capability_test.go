@@ -0,0 +1,166 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNewCapabilities verifies NewCapabilities.
+func TestNewCapabilities(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Capabilities
+		wantErr bool
+	}{
+		{
+			in: "nghttpx nghttp2/1.21.0\n",
+			want: Capabilities{
+				Version:             "1.21.0",
+				ProxyProto:          true,
+				BackendLogVariables: true,
+				PerPatternMruby:     true,
+			},
+		},
+		{
+			in: "nghttpx nghttp2/1.25.0\n",
+			want: Capabilities{
+				Version:             "1.25.0",
+				MrubyTLSClientCert:  true,
+				ProxyProto:          true,
+				BackendLogVariables: true,
+				PerPatternMruby:     true,
+			},
+		},
+		{
+			in: "nghttpx nghttp2/1.31.1\n",
+			want: Capabilities{
+				Version:             "1.31.1",
+				CookieAffinity:      true,
+				MrubyTLSClientCert:  true,
+				ProxyProto:          true,
+				BackendLogVariables: true,
+				PerPatternMruby:     true,
+			},
+		},
+		{
+			in: "nghttpx nghttp2/2.0.0\n",
+			want: Capabilities{
+				Version:             "2.0.0",
+				CookieAffinity:      true,
+				MrubyTLSClientCert:  true,
+				ProxyProto:          true,
+				BackendLogVariables: true,
+				PerPatternMruby:     true,
+			},
+		},
+		{
+			in:      "nghttpx\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := NewCapabilities(tt.in, "")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewCapabilities(%q) succeeded, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewCapabilities(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, &tt.want) {
+			t.Errorf("NewCapabilities(%q) = %+v, want %+v", tt.in, *got, tt.want)
+		}
+	}
+}
+
+// TestNewCapabilitiesOptions verifies that NewCapabilities parses the options from the output of nghttpx --help.
+func TestNewCapabilitiesOptions(t *testing.T) {
+	const helpOutput = `Usage: nghttpx [OPTIONS]... [<PRIVATE_KEY> <CERT>]
+HTTP/2 proxy
+
+Connections:
+  -b, --backend=(<HOST>,<PORT>|unix:<PATH>)[;[<PATTERN>[:...]][[;<PARAM>]...]
+              Set  backend  host  and   port.
+  --backend-read-timeout=<DURATION>
+              Specify read timeout for backend connection.
+  --tls-min-proto-version=<VER>
+              Specify minimum SSL/TLS protocol.
+`
+
+	caps, err := NewCapabilities("nghttpx nghttp2/1.25.0\n", helpOutput)
+	if err != nil {
+		t.Fatalf("NewCapabilities: %v", err)
+	}
+
+	for _, name := range []string{"backend", "backend-read-timeout", "tls-min-proto-version"} {
+		if !caps.HasOption(name) {
+			t.Errorf("caps.HasOption(%q) = false, want true", name)
+		}
+	}
+	if caps.HasOption("tls-max-early-data") {
+		t.Errorf("caps.HasOption(%q) = true, want false", "tls-max-early-data")
+	}
+	// The help output above does not describe proxyproto, mruby and affinity-cookie-name parameters, and backend variables.
+	if caps.ProxyProto {
+		t.Errorf("caps.ProxyProto = true, want false")
+	}
+	if caps.BackendLogVariables {
+		t.Errorf("caps.BackendLogVariables = true, want false")
+	}
+	if caps.PerPatternMruby {
+		t.Errorf("caps.PerPatternMruby = true, want false")
+	}
+	if caps.CookieAffinity {
+		t.Errorf("caps.CookieAffinity = true, want false")
+	}
+
+	caps, err = NewCapabilities("nghttpx nghttp2/1.25.0\n", helpOutput+`              Parameters  are  "proto=<PROTO>",  "tls",  "mruby=<PATH>",
+              and "redirect-if-not-tls".
+              If   "affinity=cookie"    is   used,    the   additional
+              "affinity-cookie-name=<NAME>" must be  used to specify a
+              name of cookie to use.
+  -f, --frontend=(<HOST>,<PORT>|unix:<PATH>)[[;<PARAM>]...]
+              To  accept  PROXY  protocol  version  1 and 2 on frontend
+              connection,  specify  "proxyproto" parameter.
+  --accesslog-format=<FORMAT>
+              * $backend_host:  backend  host   used  to  fulfill  the
+                request.
+              * $backend_port:  backend  port   used  to  fulfill  the
+                request.
+`)
+	if err != nil {
+		t.Fatalf("NewCapabilities: %v", err)
+	}
+	if !caps.ProxyProto {
+		t.Errorf("caps.ProxyProto = false, want true")
+	}
+	if !caps.BackendLogVariables {
+		t.Errorf("caps.BackendLogVariables = false, want true")
+	}
+	if !caps.PerPatternMruby {
+		t.Errorf("caps.PerPatternMruby = false, want true")
+	}
+	if !caps.CookieAffinity {
+		t.Errorf("caps.CookieAffinity = false, want true")
+	}
+
+	// If options are unknown, all options are assumed to be supported.
+	caps, err = NewCapabilities("nghttpx nghttp2/1.25.0\n", "")
+	if err != nil {
+		t.Fatalf("NewCapabilities: %v", err)
+	}
+	if !caps.HasOption("tls-max-early-data") {
+		t.Errorf("caps.HasOption(%q) = false, want true", "tls-max-early-data")
+	}
+}
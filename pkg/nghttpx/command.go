@@ -27,6 +27,7 @@ package nghttpx
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -40,10 +41,14 @@ import (
 )
 
 // Start starts a nghttpx process using nghttpx executable at path, and wait.
-func (ngx *Manager) Start(path, confPath string, stopCh <-chan struct{}) {
+func (ngx *Manager) Start(path, confPath string, stopCh <-chan struct{}, quitTimeout time.Duration) {
 	glog.Infof("Starting nghttpx process: %v --conf %v", path, confPath)
 	cmd := exec.Command(path, "--conf", confPath)
-	cmd.Stdout = os.Stdout
+	if ngx.accessLogWriter != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, ngx.accessLogWriter)
+	} else {
+		cmd.Stdout = os.Stdout
+	}
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
 		glog.Errorf("nghttpx didn't started successfully: %v", err)
@@ -66,11 +71,38 @@ func (ngx *Manager) Start(path, confPath string, stopCh <-chan struct{}) {
 		if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
 			glog.Errorf("Could not send signal to nghttpx process (PID %v): %v", cmd.Process.Pid, err)
 		}
-		<-waitDoneCh
+		var timeoutCh <-chan time.Time
+		if quitTimeout > 0 {
+			timeoutCh = time.After(quitTimeout)
+		}
+		select {
+		case <-waitDoneCh:
+		case <-timeoutCh:
+			glog.Warningf("nghttpx process (PID %v) did not exit within %v; killing it", cmd.Process.Pid, quitTimeout)
+			if err := cmd.Process.Kill(); err != nil {
+				glog.Errorf("Could not kill nghttpx process (PID %v): %v", cmd.Process.Pid, err)
+			}
+			<-waitDoneCh
+		}
 		glog.Infof("nghttpx exited")
 	}
 }
 
+// SetAccessLogWriter sets w which receives a copy of access log written by nghttpx.  It must be called before Start.
+func (ngx *Manager) SetAccessLogWriter(w io.Writer) {
+	ngx.accessLogWriter = w
+}
+
+// DetectConfigChange returns which part of the current nghttpx configuration is changed by ingressCfg without applying it.
+func (ngx *Manager) DetectConfigChange(ingressCfg *IngressConfig) (ConfigChange, error) {
+	mainConfig, backendConfig, err := ngx.generateCfg(ingressCfg)
+	if err != nil {
+		return ConfigNotChanged, err
+	}
+
+	return ngx.detectConfigChange(ingressCfg, mainConfig, backendConfig)
+}
+
 // CheckAndReload verify if the nghttpx configuration changed and sends a reload
 //
 // The current running nghttpx master process executes new nghttpx
@@ -88,10 +120,14 @@ func (ngx *Manager) CheckAndReload(ingressCfg *IngressConfig) (bool, error) {
 		return false, fmt.Errorf("failed to write new nghttpx configuration. Avoiding reload: %v", err)
 	}
 
-	if changed == configNotChanged {
+	changed = mergeConfigChange(changed, ngx.unappliedChange)
+	if changed == ConfigNotChanged {
 		return false, nil
 	}
 
+	// The files have been written.  If applying them fails, the next call must apply them again even if they are not changed.
+	ngx.unappliedChange = changed
+
 	if glog.V(3) {
 		b, err := json.MarshalIndent(ingressCfg, "", "  ")
 		if err != nil {
@@ -100,39 +136,55 @@ func (ngx *Manager) CheckAndReload(ingressCfg *IngressConfig) (bool, error) {
 		glog.Infof("nghttpx configuration:\n%v", string(b))
 	}
 
+	// Backend configuration refers to this file, so that it must be written before both reloading and replacing backends.
+	if err := ngx.writeRejectTLSMrubyFile(ingressCfg); err != nil {
+		return false, err
+	}
+
 	switch changed {
-	case mainConfigChanged:
-		oldConfRev, err := ngx.getNghttpxConfigRevision()
-		if err != nil {
+	case MainConfigChanged:
+		if err := ngx.reloadHandler(ingressCfg); err != nil {
 			return false, err
 		}
-		if err := ngx.writeTLSKeyCert(ingressCfg); err != nil {
-			return false, err
-		}
-		if err := ngx.writeMrubyFile(ingressCfg); err != nil {
-			return false, err
+	case BackendConfigChanged:
+		if err := ngx.backendReplaceHandler(ingressCfg); err != nil {
+			return false, fmt.Errorf("failed to issue backend replace request: %v", err)
 		}
+	}
 
-		cmd := "killall"
-		args := []string{"-HUP", "nghttpx"}
-		glog.Info("change in configuration detected. Reloading...")
-		out, err := exec.Command(cmd, args...).CombinedOutput()
-		if err != nil {
-			return false, fmt.Errorf("failed to execute %v %v: %v", cmd, args, string(out))
-		}
+	ngx.unappliedChange = ConfigNotChanged
 
-		if err := ngx.waitUntilConfigRevisionChanges(oldConfRev); err != nil {
-			return false, err
-		}
+	return true, nil
+}
 
-		glog.Info("nghttpx has finished reloading new configuration")
-	case backendConfigChanged:
-		if err := ngx.issueBackendReplaceRequest(ingressCfg); err != nil {
-			return false, fmt.Errorf("failed to issue backend replace request: %v", err)
-		}
+// reload writes the files which main configuration refers to, and reloads nghttpx.  It waits for nghttpx to finish reloading.
+func (ngx *Manager) reload(ingressCfg *IngressConfig) error {
+	oldConfRev, err := ngx.getNghttpxConfigRevision()
+	if err != nil {
+		return err
+	}
+	if err := ngx.writeTLSKeyCert(ingressCfg); err != nil {
+		return err
+	}
+	if err := ngx.writeMrubyFile(ingressCfg); err != nil {
+		return err
 	}
 
-	return true, nil
+	cmd := "killall"
+	args := []string{"-HUP", "nghttpx"}
+	glog.Info("change in configuration detected. Reloading...")
+	out, err := exec.Command(cmd, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to execute %v %v: %v", cmd, args, string(out))
+	}
+
+	if err := ngx.waitUntilConfigRevisionChanges(oldConfRev); err != nil {
+		return err
+	}
+
+	glog.Info("nghttpx has finished reloading new configuration")
+
+	return nil
 }
 
 func (ngx *Manager) issueBackendReplaceRequest(ingConfig *IngressConfig) error {
@@ -245,6 +297,21 @@ func (ngx *Manager) waitUntilConfigRevisionChanges(oldConfRev string) error {
 	return nil
 }
 
+// writeRejectTLSMrubyFile writes the mruby script file which rejects the requests received via TLS.  If ingConfig.RejectTLSMrubyFile is
+// nil, this function does nothing, and succeeds.
+func (ngx *Manager) writeRejectTLSMrubyFile(ingConfig *IngressConfig) error {
+	if ingConfig.RejectTLSMrubyFile == nil {
+		return nil
+	}
+
+	f := ingConfig.RejectTLSMrubyFile
+	if err := WriteFile(f.Path, f.Content); err != nil {
+		return fmt.Errorf("failed to write mruby file: %v", err)
+	}
+
+	return nil
+}
+
 // writeMrubyFile writes mruby script file.  If ingConfig.MrubyFile is nil, this function does nothing, and succeeds.
 func (ngx *Manager) writeMrubyFile(ingConfig *IngressConfig) error {
 	if ingConfig.MrubyFile == nil {
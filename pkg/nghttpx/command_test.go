@@ -0,0 +1,91 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"text/template"
+)
+
+// newTestManager returns Manager which renders simple templates, and counts the updates applied to nghttpx.  The first update of each
+// kind fails.
+func newTestManager() (ngx *Manager, reloads, backendReplaces *int) {
+	reloads, backendReplaces = new(int), new(int)
+	ngx = &Manager{
+		template:        template.Must(template.New("main").Parse("workers={{.Workers}}\n")),
+		backendTemplate: template.Must(template.New("backend").Parse("{{range .Upstreams}}backend={{.Name}}\n{{end}}")),
+	}
+	ngx.reloadHandler = func(ingConfig *IngressConfig) error {
+		*reloads++
+		if *reloads == 1 {
+			return errors.New("reload failed")
+		}
+		return nil
+	}
+	ngx.backendReplaceHandler = func(ingConfig *IngressConfig) error {
+		*backendReplaces++
+		if *backendReplaces == 1 {
+			return errors.New("backend replace failed")
+		}
+		return nil
+	}
+	return ngx, reloads, backendReplaces
+}
+
+// TestCheckAndReloadRetriesFailedUpdate verifies that CheckAndReload applies the configuration again after it failed to apply it, even
+// though the configuration files have already been written.
+func TestCheckAndReloadRetriesFailedUpdate(t *testing.T) {
+	confDir, err := ioutil.TempDir("", "nghttpx-ingress-lb")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(confDir)
+
+	ngx, reloads, backendReplaces := newTestManager()
+
+	ingConfig := NewIngressConfig()
+	ingConfig.ConfDir = confDir
+	ingConfig.Workers = "1"
+
+	if _, err := ngx.CheckAndReload(ingConfig); err == nil {
+		t.Fatalf("ngx.CheckAndReload(...) returned no error; want error")
+	}
+	if got, err := ngx.DetectConfigChange(ingConfig); err != nil || got != MainConfigChanged {
+		t.Errorf("ngx.DetectConfigChange(...) = %v, %v; want %v, nil", got, err, MainConfigChanged)
+	}
+	if reloaded, err := ngx.CheckAndReload(ingConfig); err != nil || !reloaded {
+		t.Fatalf("ngx.CheckAndReload(...) = %v, %v; want true, nil", reloaded, err)
+	}
+	if got, want := *reloads, 2; got != want {
+		t.Errorf("reloads = %v, want %v", got, want)
+	}
+	if reloaded, err := ngx.CheckAndReload(ingConfig); err != nil || reloaded {
+		t.Fatalf("ngx.CheckAndReload(...) = %v, %v; want false, nil", reloaded, err)
+	}
+
+	ingConfig.Upstreams = []*Upstream{{Name: "foo"}}
+
+	if _, err := ngx.CheckAndReload(ingConfig); err == nil {
+		t.Fatalf("ngx.CheckAndReload(...) returned no error; want error")
+	}
+	if got, err := ngx.DetectConfigChange(ingConfig); err != nil || got != BackendConfigChanged {
+		t.Errorf("ngx.DetectConfigChange(...) = %v, %v; want %v, nil", got, err, BackendConfigChanged)
+	}
+	if reloaded, err := ngx.CheckAndReload(ingConfig); err != nil || !reloaded {
+		t.Fatalf("ngx.CheckAndReload(...) = %v, %v; want true, nil", reloaded, err)
+	}
+	if got, want := *backendReplaces, 2; got != want {
+		t.Errorf("backendReplaces = %v, want %v", got, want)
+	}
+	if got, err := ngx.DetectConfigChange(ingConfig); err != nil || got != ConfigNotChanged {
+		t.Errorf("ngx.DetectConfigChange(...) = %v, %v; want %v, nil", got, err, ConfigNotChanged)
+	}
+}
@@ -26,7 +26,9 @@ package nghttpx
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -49,6 +51,22 @@ type Manager struct {
 	backendconfigURI string
 	// configrevisionURI is the nghttpx configrevision endpoint.
 	configrevisionURI string
+
+	capsMu sync.Mutex
+	// caps is the features which nghttpx supports.
+	caps *Capabilities
+
+	// accessLogWriter, if not nil, receives a copy of nghttpx standard output, which includes access log.
+	accessLogWriter io.Writer
+
+	// unappliedChange is the configuration change which has been written to files, but has not been applied to nghttpx successfully.
+	// Change detection takes it into account, so that the failed update is retried even if the files are not changed since then.  It
+	// is only accessed from sync.
+	unappliedChange ConfigChange
+	// reloadHandler reloads nghttpx with the main configuration written in files.  It is replaced in tests.
+	reloadHandler func(ingConfig *IngressConfig) error
+	// backendReplaceHandler updates the backends of nghttpx with the backend configuration written in files.  It is replaced in tests.
+	backendReplaceHandler func(ingConfig *IngressConfig) error
 }
 
 // NewManager ...
@@ -63,9 +81,34 @@ func NewManager(apiPort int) *Manager {
 		},
 		backendconfigURI:  fmt.Sprintf("http://127.0.0.1:%v/api/v1beta1/backendconfig", apiPort),
 		configrevisionURI: fmt.Sprintf("http://127.0.0.1:%v/api/v1beta1/configrevision", apiPort),
+		caps:              &Capabilities{},
 	}
 
+	ngx.reloadHandler = ngx.reload
+	ngx.backendReplaceHandler = ngx.issueBackendReplaceRequest
+
 	ngx.loadTemplate()
 
 	return ngx
 }
+
+// DetectCapabilities runs nghttpx executable at path to detect the features it supports, and remembers the result.
+func (ngx *Manager) DetectCapabilities(path string) (*Capabilities, error) {
+	caps, err := DetectCapabilities(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ngx.capsMu.Lock()
+	defer ngx.capsMu.Unlock()
+	ngx.caps = caps
+
+	return caps, nil
+}
+
+// Capabilities returns the features which nghttpx supports.
+func (ngx *Manager) Capabilities() *Capabilities {
+	ngx.capsMu.Lock()
+	defer ngx.capsMu.Unlock()
+	return ngx.caps
+}
@@ -0,0 +1,203 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// RouteLookupResult is the result of LookupRoute.
+type RouteLookupResult struct {
+	// Host is the normalized request host.
+	Host string
+	// Path is the normalized request path.
+	Path string
+	// Upstream is the name of matched upstream.  It is empty if no upstream matches.
+	Upstream string
+	// Source is the key of Ingress which the matched upstream is created from.  It is empty for the default backend of the controller.
+	Source string
+	// Pattern is the backend pattern of the matched upstream.
+	Pattern string
+	// Backends is the backend servers of the matched upstream.
+	Backends []UpstreamServer
+	// RedirectIfNotTLS is true if the request is redirected to https URI unless it is TLS encrypted.
+	RedirectIfNotTLS bool
+	// NearMisses is the list of upstreams which almost match the request.
+	NearMisses []RouteNearMiss
+}
+
+// RouteNearMiss is an upstream which almost matches a request.
+type RouteNearMiss struct {
+	// Upstream is the name of upstream.
+	Upstream string
+	// Source is the key of Ingress which the upstream is created from.
+	Source string
+	// Pattern is the backend pattern of the upstream.
+	Pattern string
+	// Reason describes why the upstream does not match.
+	Reason string
+}
+
+// LookupRoute returns the upstream in upstreams which serves the request with host and path.  It follows the backend pattern matching
+// of nghttpx: first, the patterns with the exact host are tried, then the patterns with wildcard host in the descending order of suffix
+// length, and finally the patterns without host.  Among the patterns with the same host, the longest path wins.  A path which ends with
+// "/" matches the request path which has it as prefix, and the request path which equals to it without the trailing "/".  Otherwise, the
+// path only matches the same request path.
+func LookupRoute(upstreams []*Upstream, host, path string) *RouteLookupResult {
+	host = normalizeRouteHost(host)
+	path = normalizeRoutePath(path)
+
+	res := &RouteLookupResult{
+		Host: host,
+		Path: path,
+	}
+
+	matched := matchRoute(upstreams, host, path)
+	if matched != nil {
+		res.Upstream = matched.Name
+		res.Source = matched.Source
+		res.Pattern = matched.Host + upstreamPath(matched)
+		res.Backends = matched.Backends
+		res.RedirectIfNotTLS = matched.RedirectIfNotTLS
+	}
+
+	// Host mismatches are interesting only if the request is not routed by host.
+	fallback := matched == nil || matched.Host == ""
+
+	for _, ups := range upstreams {
+		if ups == matched {
+			continue
+		}
+		if reason := routeNearMissReason(ups, host, path, fallback); reason != "" {
+			res.NearMisses = append(res.NearMisses, RouteNearMiss{
+				Upstream: ups.Name,
+				Source:   ups.Source,
+				Pattern:  ups.Host + upstreamPath(ups),
+				Reason:   reason,
+			})
+		}
+	}
+
+	return res
+}
+
+// normalizeRouteHost lowercases host, and removes port.
+func normalizeRouteHost(host string) string {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// normalizeRoutePath removes query and fragment from path.  Empty path is treated as "/".
+func normalizeRoutePath(path string) string {
+	if i := strings.IndexAny(path, "?#"); i != -1 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// matchRoute returns the upstream which serves the request with host and path.  It returns nil if there is no such upstream.
+func matchRoute(upstreams []*Upstream, host, path string) *Upstream {
+	if ups := matchRoutePath(upstreams, host, path); ups != nil {
+		return ups
+	}
+
+	var wildcardHosts []string
+	seen := make(map[string]bool)
+	for _, ups := range upstreams {
+		if !strings.HasPrefix(ups.Host, "*") || seen[ups.Host] {
+			continue
+		}
+		seen[ups.Host] = true
+		wildcardHosts = append(wildcardHosts, ups.Host)
+	}
+	sort.Slice(wildcardHosts, func(i, j int) bool {
+		if len(wildcardHosts[i]) != len(wildcardHosts[j]) {
+			return len(wildcardHosts[i]) > len(wildcardHosts[j])
+		}
+		return wildcardHosts[i] < wildcardHosts[j]
+	})
+
+	for _, wildcardHost := range wildcardHosts {
+		if !wildcardHostMatches(wildcardHost, host) {
+			continue
+		}
+		if ups := matchRoutePath(upstreams, wildcardHost, path); ups != nil {
+			return ups
+		}
+	}
+
+	return matchRoutePath(upstreams, "", path)
+}
+
+// wildcardHostMatches returns true if host matches wildcardHost which starts with "*".  "*" must match at least one character.
+func wildcardHostMatches(wildcardHost, host string) bool {
+	suffix := strings.ToLower(wildcardHost[1:])
+	return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+}
+
+// matchRoutePath returns the upstream which has host, and the longest path matching path.
+func matchRoutePath(upstreams []*Upstream, host, path string) *Upstream {
+	var best *Upstream
+	for _, ups := range upstreams {
+		if strings.ToLower(ups.Host) != host || !routePathMatches(upstreamPath(ups), path) {
+			continue
+		}
+		if best == nil || len(upstreamPath(ups)) > len(upstreamPath(best)) {
+			best = ups
+		}
+	}
+	return best
+}
+
+// upstreamPath returns the path of ups.  Empty path is treated as "/" as nghttpx does.
+func upstreamPath(ups *Upstream) string {
+	if ups.Path == "" {
+		return "/"
+	}
+	return ups.Path
+}
+
+// routePathMatches returns true if request path matches pattern.
+func routePathMatches(pattern, path string) bool {
+	if pattern == path {
+		return true
+	}
+	if !strings.HasSuffix(pattern, "/") {
+		return false
+	}
+	return strings.HasPrefix(path, pattern) || path == pattern[:len(pattern)-1]
+}
+
+// routeNearMissReason returns the reason why ups does not match the request with host and path if it almost matches.  It returns empty
+// string if ups is not a near miss.  If hostMismatch is false, the upstreams which only differ in host are not considered as near miss.
+func routeNearMissReason(ups *Upstream, host, path string, hostMismatch bool) string {
+	upsHost := strings.ToLower(ups.Host)
+	upsPath := upstreamPath(ups)
+	hostMatches := upsHost == "" || upsHost == host || (strings.HasPrefix(upsHost, "*") && wildcardHostMatches(upsHost, host))
+	pathMatches := routePathMatches(upsPath, path)
+
+	switch {
+	case hostMatches && !strings.HasSuffix(upsPath, "/") && path == upsPath+"/":
+		return fmt.Sprintf("path %v does not match the request path with trailing /", upsPath)
+	case hostMatches && !strings.HasSuffix(upsPath, "/") && strings.HasPrefix(path, upsPath+"/"):
+		return fmt.Sprintf("path %v matches only the exact path; it must end with / to match the paths under it", upsPath)
+	case hostMismatch && !hostMatches && pathMatches:
+		return fmt.Sprintf("host %v does not match %v", ups.Host, host)
+	}
+
+	return ""
+}
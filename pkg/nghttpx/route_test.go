@@ -0,0 +1,104 @@
+/**
+ * Copyright 2017, nghttpx Ingress controller contributors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nghttpx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestLookupRoute verifies LookupRoute.
+func TestLookupRoute(t *testing.T) {
+	upstreams := []*Upstream{
+		{Name: "default/alpha,80;alpha.test/", Host: "alpha.test", Path: "/", Source: "default/alpha"},
+		{Name: "default/alpha-api,80;alpha.test/api/", Host: "alpha.test", Path: "/api/", Source: "default/alpha"},
+		{Name: "default/alpha-login,80;alpha.test/login", Host: "alpha.test", Path: "/login", Source: "default/alpha"},
+		{Name: "default/bravo,80;*.bravo.test/", Host: "*.bravo.test", Path: "/", Source: "default/bravo"},
+		{Name: "default/charlie,80;/static/", Path: "/static/", Source: "default/charlie"},
+		{Name: "kube-system/default-http-backend", RedirectIfNotTLS: true},
+	}
+
+	tests := []struct {
+		desc           string
+		host           string
+		path           string
+		wantUpstream   string
+		wantNearMisses []string
+	}{
+		{
+			desc:         "exact host and longest path",
+			host:         "alpha.test",
+			path:         "/api/users?id=1",
+			wantUpstream: "default/alpha-api,80;alpha.test/api/",
+		},
+		{
+			desc:         "path without trailing slash matches the pattern with it",
+			host:         "Alpha.Test:8443",
+			path:         "/api",
+			wantUpstream: "default/alpha-api,80;alpha.test/api/",
+		},
+		{
+			desc:           "exact path does not match the path under it",
+			host:           "alpha.test",
+			path:           "/login/",
+			wantUpstream:   "default/alpha,80;alpha.test/",
+			wantNearMisses: []string{"default/alpha-login,80;alpha.test/login"},
+		},
+		{
+			desc:         "wildcard host",
+			host:         "www.bravo.test",
+			path:         "/",
+			wantUpstream: "default/bravo,80;*.bravo.test/",
+		},
+		{
+			desc:         "wildcard does not match empty label",
+			host:         ".bravo.test",
+			path:         "/static/app.js",
+			wantUpstream: "default/charlie,80;/static/",
+			wantNearMisses: []string{
+				"default/bravo,80;*.bravo.test/",
+				"default/alpha,80;alpha.test/",
+			},
+		},
+		{
+			desc:         "unknown host goes to default backend",
+			host:         "delta.test",
+			path:         "/api/",
+			wantUpstream: "kube-system/default-http-backend",
+			wantNearMisses: []string{
+				"default/alpha,80;alpha.test/",
+				"default/alpha-api,80;alpha.test/api/",
+				"default/bravo,80;*.bravo.test/",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		res := LookupRoute(upstreams, tt.host, tt.path)
+		if got, want := res.Upstream, tt.wantUpstream; got != want {
+			t.Errorf("%v: res.Upstream = %v, want %v", tt.desc, got, want)
+		}
+
+		var nearMisses []string
+		for _, nm := range res.NearMisses {
+			nearMisses = append(nearMisses, nm.Upstream)
+		}
+		// Ignore order.
+		got := make(map[string]bool)
+		for _, s := range nearMisses {
+			got[s] = true
+		}
+		want := make(map[string]bool)
+		for _, s := range tt.wantNearMisses {
+			want[s] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: res.NearMisses = %v, want %v", tt.desc, nearMisses, tt.wantNearMisses)
+		}
+	}
+}
@@ -26,6 +26,7 @@ package nghttpx
 
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"text/template"
 
@@ -52,15 +53,32 @@ func (ngx *Manager) loadTemplate() {
 	ngx.backendTemplate = template.Must(template.New("nghttpx-backend.tmpl").Funcs(funcMap).ParseFiles("./nghttpx-backend.tmpl"))
 }
 
+// ConfigChange describes which part of nghttpx configuration has changed.
+type ConfigChange int
+
 const (
-	// configuration has not changed
-	configNotChanged int = iota
-	// main configuration has changed
-	mainConfigChanged
-	// only backend configuration has changed
-	backendConfigChanged
+	// ConfigNotChanged means that configuration has not changed.
+	ConfigNotChanged ConfigChange = iota
+	// MainConfigChanged means that main configuration has changed, and nghttpx must be reloaded.
+	MainConfigChanged
+	// BackendConfigChanged means that only backend configuration has changed, and it can be updated through backendconfig API.
+	BackendConfigChanged
 )
 
+// String returns the name of c.
+func (c ConfigChange) String() string {
+	switch c {
+	case ConfigNotChanged:
+		return "none"
+	case MainConfigChanged:
+		return "main"
+	case BackendConfigChanged:
+		return "backend"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(c))
+	}
+}
+
 // generateCfg generates nghttpx's main and backend configurations.
 func (ngx *Manager) generateCfg(ingConfig *IngressConfig) ([]byte, []byte, error) {
 	mainConfigBuffer := new(bytes.Buffer)
@@ -78,46 +96,80 @@ func (ngx *Manager) generateCfg(ingConfig *IngressConfig) ([]byte, []byte, error
 	return mainConfigBuffer.Bytes(), backendConfigBuffer.Bytes(), nil
 }
 
-func (ngx *Manager) checkAndWriteCfg(ingConfig *IngressConfig, mainConfig, backendConfig []byte) (int, error) {
+// detectConfigChange returns which part of the configuration written in ingConfig.ConfDir differs from mainConfig and backendConfig.  The
+// change which was written, but failed to be applied is also included.
+func (ngx *Manager) detectConfigChange(ingConfig *IngressConfig, mainConfig, backendConfig []byte) (ConfigChange, error) {
+	mainChanged, err := needsReload(NghttpxConfigPath(ingConfig.ConfDir), mainConfig)
+	if err != nil {
+		return ConfigNotChanged, err
+	}
+	if mainChanged {
+		return MainConfigChanged, nil
+	}
+
+	backendChanged, err := needsReload(NghttpxBackendConfigPath(ingConfig.ConfDir), backendConfig)
+	if err != nil {
+		return ConfigNotChanged, err
+	}
+	if backendChanged {
+		return mergeConfigChange(BackendConfigChanged, ngx.unappliedChange), nil
+	}
+
+	return ngx.unappliedChange, nil
+}
+
+// mergeConfigChange returns the change which covers both a and b.  Main configuration change covers backend configuration change
+// because reloading nghttpx also reads backend configuration.
+func mergeConfigChange(a, b ConfigChange) ConfigChange {
+	if a == MainConfigChanged || b == MainConfigChanged {
+		return MainConfigChanged
+	}
+	if a == BackendConfigChanged || b == BackendConfigChanged {
+		return BackendConfigChanged
+	}
+	return ConfigNotChanged
+}
+
+func (ngx *Manager) checkAndWriteCfg(ingConfig *IngressConfig, mainConfig, backendConfig []byte) (ConfigChange, error) {
 	configPath := NghttpxConfigPath(ingConfig.ConfDir)
 	backendConfigPath := NghttpxBackendConfigPath(ingConfig.ConfDir)
 
 	if err := MkdirAll(ingConfig.ConfDir); err != nil {
-		return configNotChanged, err
+		return ConfigNotChanged, err
 	}
 
 	// If main configuration has changed, we need to reload nghttpx
 	mainChanged, err := needsReload(configPath, mainConfig)
 	if err != nil {
-		return configNotChanged, err
+		return ConfigNotChanged, err
 	}
 
 	// If backend configuration has changed, we need to issue
 	// backend replace API to nghttpx
 	backendChanged, err := needsReload(backendConfigPath, backendConfig)
 	if err != nil {
-		return configNotChanged, err
+		return ConfigNotChanged, err
 	}
 
 	if mainChanged {
 		if err := WriteFile(configPath, mainConfig); err != nil {
-			return configNotChanged, err
+			return ConfigNotChanged, err
 		}
 	}
 
 	if backendChanged {
 		if err := WriteFile(backendConfigPath, backendConfig); err != nil {
-			return configNotChanged, err
+			return ConfigNotChanged, err
 		}
 	}
 
 	if mainChanged {
-		return mainConfigChanged, nil
+		return MainConfigChanged, nil
 	}
 
 	if backendChanged {
-		return backendConfigChanged, nil
+		return BackendConfigChanged, nil
 	}
 
-	return configNotChanged, nil
+	return ConfigNotChanged, nil
 }
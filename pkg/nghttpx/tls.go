@@ -25,16 +25,23 @@ limitations under the License.
 package nghttpx
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/golang/glog"
+	"golang.org/x/crypto/ed25519"
 
 	"k8s.io/client-go/pkg/api/v1"
 )
@@ -44,6 +51,9 @@ const (
 	tlsDir = "tls"
 )
 
+// oidPublicKeyEd25519 is the algorithm identifier of Ed25519 defined in RFC 8410.
+var oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
 //CreateTLSKeyPath returns TLS private key file path.
 func CreateTLSKeyPath(dir, name string) string {
 	return filepath.Join(dir, tlsDir, fmt.Sprintf("%v.key", name))
@@ -59,6 +69,30 @@ func CreateTLSOCSPRespPath(dir, name string) string {
 	return filepath.Join(dir, tlsDir, fmt.Sprintf("%v.ocsp-resp", name))
 }
 
+// CreateTLSCACertPath returns CA certificate file path.
+func CreateTLSCACertPath(dir, name string) string {
+	return filepath.Join(dir, tlsDir, fmt.Sprintf("%v.ca.crt", name))
+}
+
+// BackendCACertPath returns the path to CA certificate bundle to verify backend server certificate.
+func BackendCACertPath(dir string) string {
+	return filepath.Join(dir, tlsDir, "backend-ca.crt")
+}
+
+// TLSTicketKeyPath returns the path to the TLS session ticket key file.  n is the index of the key.
+func TLSTicketKeyPath(dir string, n int) string {
+	return filepath.Join(dir, tlsDir, fmt.Sprintf("tls-ticket-key-%v", n))
+}
+
+// CreateCACert creates ChecksumFile for given CA certificate.
+func CreateCACert(dir, name string, caCert []byte) *ChecksumFile {
+	return &ChecksumFile{
+		Path:     CreateTLSCACertPath(dir, name),
+		Content:  caCert,
+		Checksum: Checksum(caCert),
+	}
+}
+
 // CreateTLSCred creates TLSCred for given private key and certificate.  ocspResp is optional, and could be nil.
 func CreateTLSCred(dir, name string, cert, key, ocspResp []byte) (*TLSCred, error) {
 	return &TLSCred{
@@ -98,6 +132,29 @@ func (ngx *Manager) writeTLSKeyCert(ingConfig *IngressConfig) error {
 		}
 	}
 
+	if ingConfig.ClientCACert != nil {
+		if err := WriteFile(ingConfig.ClientCACert.Path, ingConfig.ClientCACert.Content); err != nil {
+			return fmt.Errorf("failed to write client CA certificate: %v", err)
+		}
+	}
+
+	if ingConfig.BackendCACert != nil {
+		if err := WriteFile(ingConfig.BackendCACert.Path, ingConfig.BackendCACert.Content); err != nil {
+			return fmt.Errorf("failed to write backend CA certificate: %v", err)
+		}
+	}
+
+	if ingConfig.BackendClientTLSCred != nil {
+		if err := writeTLSKeyCert(ingConfig.BackendClientTLSCred); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range ingConfig.TLSTicketKeyFiles {
+		if err := WriteFile(f.Path, f.Content); err != nil {
+			return fmt.Errorf("failed to write TLS session ticket key: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -145,18 +202,250 @@ func CommonNames(certBlob []byte) ([]string, error) {
 
 // checkPrivateKey checks if the key is valid.
 func CheckPrivateKey(keyBlob []byte) error {
-	block, _ := pem.Decode(keyBlob)
-	if block == nil {
-		return fmt.Errorf("No valid PEM formatted block found from private key")
-	}
-
-	if _, err := parsePrivateKey(block.Bytes); err != nil {
+	if _, err := decodePrivateKey(keyBlob); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// decodePrivateKey decodes the first private key found in keyBlob in PEM format.  The blocks which do not contain private key (e.g., "EC
+// PARAMETERS" which openssl emits before EC private key) are skipped.
+func decodePrivateKey(keyBlob []byte) (crypto.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, keyBlob = pem.Decode(keyBlob)
+		if block == nil {
+			return nil, fmt.Errorf("No valid PEM formatted block found from private key")
+		}
+
+		if block.Type == "EC PARAMETERS" {
+			continue
+		}
+
+		return parsePrivateKey(block.Bytes)
+	}
+}
+
+// decodeCertificates decodes all certificates found in certBlob in PEM format.  The blocks other than "CERTIFICATE" are ignored.
+func decodeCertificates(certBlob []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, certBlob = pem.Decode(certBlob)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No valid PEM formatted block found from certificate")
+	}
+
+	return certs, nil
+}
+
+// CheckCertificates checks that certBlob contains at least one certificate in PEM format, and all of them are valid.
+func CheckCertificates(certBlob []byte) error {
+	_, err := decodeCertificates(certBlob)
+	return err
+}
+
+// VerifyKeyPair verifies that the private key in keyBlob matches the public key of one of the certificates in certBlob, and the remaining
+// certificates form the issuer chain of that leaf certificate.  Both certBlob and keyBlob must be in PEM format.  It returns the certificate
+// chain ordered from the leaf certificate to the topmost issuer in PEM format.  If certBlob is already ordered, it is returned as is.
+func VerifyKeyPair(certBlob, keyBlob []byte) ([]byte, error) {
+	certs, err := decodeCertificates(certBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := decodePrivateKey(keyBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Private key cannot be used for signing")
+	}
+
+	leafIdx := -1
+	for i, cert := range certs {
+		if publicKeyEqual(certificatePublicKey(cert), signer.Public()) {
+			leafIdx = i
+			break
+		}
+	}
+
+	if leafIdx == -1 {
+		return nil, errors.New("Private key does not match the public key of any certificate")
+	}
+
+	chain := []*x509.Certificate{certs[leafIdx]}
+	rest := append(append([]*x509.Certificate(nil), certs[:leafIdx]...), certs[leafIdx+1:]...)
+
+	for len(rest) > 0 {
+		cur := chain[len(chain)-1]
+		if bytes.Equal(cur.RawIssuer, cur.RawSubject) {
+			// Self-signed certificate terminates the chain.
+			break
+		}
+
+		issuerIdx := -1
+		for i, cert := range rest {
+			if cur.CheckSignatureFrom(cert) == nil {
+				issuerIdx = i
+				break
+			}
+		}
+
+		if issuerIdx == -1 {
+			break
+		}
+
+		chain = append(chain, rest[issuerIdx])
+		rest = append(rest[:issuerIdx], rest[issuerIdx+1:]...)
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("Certificate %q is not a part of the certificate chain of %q", rest[0].Subject.CommonName,
+			chain[0].Subject.CommonName)
+	}
+
+	ordered := true
+	for i := range chain {
+		if chain[i] != certs[i] {
+			ordered = false
+			break
+		}
+	}
+
+	if ordered {
+		return certBlob, nil
+	}
+
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// publicKeyEqual returns true if a and b are the same public key.
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.N.Cmp(b.N) == 0 && a.E == b.E
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.Curve == b.Curve && a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+	case ed25519.PublicKey:
+		b, ok := b.(ed25519.PublicKey)
+		return ok && bytes.Equal(a, b)
+	default:
+		return false
+	}
+}
+
+// certificatePublicKey returns the public key of cert.  crypto/x509 before Go 1.13 does not know Ed25519, and leaves cert.PublicKey nil.
+// In that case, Ed25519 public key is parsed from cert.RawSubjectPublicKeyInfo.
+func certificatePublicKey(cert *x509.Certificate) crypto.PublicKey {
+	if cert.PublicKey != nil {
+		return cert.PublicKey
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil || len(rest) != 0 {
+		return nil
+	}
+	if !spki.Algorithm.Algorithm.Equal(oidPublicKeyEd25519) || len(spki.PublicKey.Bytes) != ed25519.PublicKeySize {
+		return nil
+	}
+
+	return ed25519.PublicKey(spki.PublicKey.Bytes)
+}
+
+// parseEd25519PrivateKey parses Ed25519 private key in PKCS#8 form given in der.  crypto/x509 before Go 1.13 cannot parse it.
+func parseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var pkcs8 struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(der, &pkcs8); err != nil {
+		return nil, err
+	}
+	if !pkcs8.Algo.Algorithm.Equal(oidPublicKeyEd25519) {
+		return nil, errors.New("Not an Ed25519 private key")
+	}
+
+	// The private key is the seed wrapped in OCTET STRING.
+	var seed []byte
+	if _, err := asn1.Unmarshal(pkcs8.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Invalid Ed25519 private key length %v", len(seed))
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// UncoveredHosts returns the hosts which are not covered by the first certificate in certBlob.  The first certificate is treated as leaf
+// certificate.  Wildcard host (e.g., *.example.com) is covered only if the certificate has the same wildcard name.
+func UncoveredHosts(certBlob []byte, hosts []string) ([]string, error) {
+	certs, err := decodeCertificates(certBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := certs[0]
+
+	var uncovered []string
+
+	for _, host := range hosts {
+		if strings.HasPrefix(host, "*.") {
+			var found bool
+			for _, name := range leaf.DNSNames {
+				if strings.EqualFold(name, host) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				uncovered = append(uncovered, host)
+			}
+			continue
+		}
+
+		if err := leaf.VerifyHostname(host); err != nil {
+			uncovered = append(uncovered, host)
+		}
+	}
+
+	return uncovered, nil
+}
+
 // parsePrivateKey parses private key given in der.  This function is
 // copied from crypto/tls/tls.go and has been modified to suite to our
 // need.  The origina code has the following copyright notice:
@@ -171,13 +460,17 @@ func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
 
 	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
 		switch key := key.(type) {
-		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
 			return key, nil
 		default:
 			return nil, errors.New("Found unknown private key type in PKCS#8 wrapping")
 		}
 	}
 
+	if key, err := parseEd25519PrivateKey(der); err == nil {
+		return key, nil
+	}
+
 	if key, err := x509.ParseECPrivateKey(der); err == nil {
 		return key, nil
 	}
@@ -185,7 +478,8 @@ func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
 	return nil, errors.New("Failed to parse private key")
 }
 
-// RemoveDuplicatePems removes duplicates from pems.  It assumes that pems are sorted using TLSCredKeyLess.
+// RemoveDuplicatePems removes duplicates from pems.  It assumes that pems are sorted using TLSCredKeyLess.  The hosts of removed
+// duplicates are merged into the remaining one.
 func RemoveDuplicatePems(pems []*TLSCred) []*TLSCred {
 	if len(pems) == 0 {
 		return pems
@@ -194,6 +488,7 @@ func RemoveDuplicatePems(pems []*TLSCred) []*TLSCred {
 	j := 0
 	for i, _ := range left {
 		if pems[j].Key.Path == left[i].Key.Path {
+			pems[j].Hosts = MergeHosts(pems[j].Hosts, left[i].Hosts)
 			continue
 		}
 		j++
@@ -208,3 +503,105 @@ func RemoveDuplicatePems(pems []*TLSCred) []*TLSCred {
 func TLSCredPrefix(secret *v1.Secret) string {
 	return fmt.Sprintf("%v_%v", secret.Namespace, secret.Name)
 }
+
+// MergeHosts returns the sorted union of host names in a and b.  Host names are lower-cased.  It returns nil if both a and b are empty.
+func MergeHosts(a, b []string) []string {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	hosts := make([]string, 0, len(a)+len(b))
+	for _, h := range a {
+		hosts = append(hosts, strings.ToLower(h))
+	}
+	for _, h := range b {
+		hosts = append(hosts, strings.ToLower(h))
+	}
+
+	sort.Strings(hosts)
+
+	j := 0
+	for i := 1; i < len(hosts); i++ {
+		if hosts[j] == hosts[i] {
+			continue
+		}
+		j++
+		hosts[j] = hosts[i]
+	}
+
+	return hosts[:j+1]
+}
+
+const (
+	// TLSTicketKeySize is the length of TLS session ticket key for aes-128-cbc cipher, which is the default cipher of nghttpx.
+	TLSTicketKeySize = 48
+)
+
+// NewTLSTicketKey returns newly generated TLS session ticket key.
+func NewTLSTicketKey() ([]byte, error) {
+	key := make([]byte, TLSTicketKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Could not generate TLS session ticket key: %v", err)
+	}
+	return key, nil
+}
+
+// UpdateTLSTicketKey rotates TLS session ticket keys, and returns the result.  keys is a concatenation of TLS session ticket keys.  The
+// first key is used for encryption, and the remaining keys are used for decryption only.  The second key is the next encryption key.  It
+// is distributed for decryption only until the next rotation, so that all nghttpx instances can decrypt tickets encrypted with it by the
+// time it is used for encryption.  UpdateTLSTicketKey promotes the second key to the encryption key, puts the current encryption key
+// after it, and inserts newly generated key as the next encryption key.  If keys has less than 2 keys, the existing keys are kept in
+// place, and new keys are appended to make 2 keys.  The number of keys in the result does not exceed maxKeys, which must be at least 2.
+func UpdateTLSTicketKey(keys []byte, maxKeys int) ([]byte, error) {
+	if len(keys) < 2*TLSTicketKeySize {
+		for len(keys) < 2*TLSTicketKeySize {
+			key, err := NewTLSTicketKey()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key...)
+		}
+		return keys, nil
+	}
+
+	key, err := NewTLSTicketKey()
+	if err != nil {
+		return nil, err
+	}
+
+	newKeys := make([]byte, 0, len(keys)+TLSTicketKeySize)
+	newKeys = append(newKeys, keys[TLSTicketKeySize:2*TLSTicketKeySize]...)
+	newKeys = append(newKeys, key...)
+	newKeys = append(newKeys, keys[:TLSTicketKeySize]...)
+	newKeys = append(newKeys, keys[2*TLSTicketKeySize:]...)
+	if len(newKeys) > maxKeys*TLSTicketKeySize {
+		newKeys = newKeys[:maxKeys*TLSTicketKeySize]
+	}
+
+	return newKeys, nil
+}
+
+// VerifyTLSTicketKey returns an error if keys is not a concatenation of one or more TLS session ticket keys.
+func VerifyTLSTicketKey(keys []byte) error {
+	if len(keys) == 0 {
+		return errors.New("No TLS session ticket key found")
+	}
+	if len(keys)%TLSTicketKeySize != 0 {
+		return fmt.Errorf("The length of TLS session ticket keys must be a multiple of %v", TLSTicketKeySize)
+	}
+	return nil
+}
+
+// CreateTLSTicketKeyFiles creates ChecksumFile for each TLS session ticket key in keys.  keys must be verified by VerifyTLSTicketKey.
+func CreateTLSTicketKeyFiles(dir string, keys []byte) []*ChecksumFile {
+	var files []*ChecksumFile
+	for i := 0; i < len(keys)/TLSTicketKeySize; i++ {
+		key := keys[i*TLSTicketKeySize : (i+1)*TLSTicketKeySize]
+		files = append(files, &ChecksumFile{
+			Path:     TLSTicketKeyPath(dir, i),
+			Content:  key,
+			Checksum: Checksum(key),
+		})
+	}
+	return files
+}
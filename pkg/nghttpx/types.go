@@ -27,17 +27,27 @@ package nghttpx
 import (
 	"runtime"
 	"strconv"
+	"time"
 )
 
 // Interface is the API to update underlying load balancer.
 type Interface interface {
 	// Start starts a nghttpx process using executable at path with configuration file at confPath, and wait.  If stopCh becomes
-	// readable, kill nghttpx process, and return.
-	Start(path, confPath string, stopCh <-chan struct{})
+	// readable, make nghttpx process shut down gracefully, and return after it exits.  If quitTimeout is positive, and nghttpx does
+	// not exit within quitTimeout, it is killed.
+	Start(path, confPath string, stopCh <-chan struct{}, quitTimeout time.Duration)
 	// CheckAndReload checks whether the nghttpx configuration changed, and if so, make nghttpx reload its configuration.  If reloading
 	// is required, and it successfully issues reloading, returns true.  If there is no need to reloading, it returns false.  On error,
 	// it returns false, and non-nil error.
 	CheckAndReload(ingressCfg *IngressConfig) (bool, error)
+	// DetectConfigChange returns which part of the current nghttpx configuration would be changed if ingressCfg is applied by
+	// CheckAndReload.
+	DetectConfigChange(ingressCfg *IngressConfig) (ConfigChange, error)
+	// DetectCapabilities runs nghttpx executable at path to detect the features it supports, and remembers the result.  On error, it
+	// returns nil, and non-nil error.
+	DetectCapabilities(path string) (*Capabilities, error)
+	// Capabilities returns the features which nghttpx supports.  If they have not been detected yet, it returns empty Capabilities.
+	Capabilities() *Capabilities
 }
 
 // IngressConfig describes an nghttpx configuration
@@ -47,34 +57,91 @@ type IngressConfig struct {
 	DefaultTLSCred *TLSCred
 	SubTLSCred     []*TLSCred
 	// https://nghttp2.org/documentation/nghttpx.1.html#cmdoption-nghttpx-n
-	// Set the number of worker threads.
+	// DefaultWorkers is the number of worker threads used unless it is specified in ConfigMap.
+	DefaultWorkers string
+	// Workers is the number of worker threads specified in ConfigMap.
 	Workers string
-	// ExtraConfig is the extra configurations in a format that nghttpx accepts in --conf.
+	// The following fields are typed settings read from ConfigMap.  They are already validated, and empty string means that the
+	// setting is not specified, and nghttpx default is used.  See nghttpx documentation for the meaning of each field.
+	FrontendReadTimeout       string
+	FrontendWriteTimeout      string
+	FrontendKeepAliveTimeout  string
+	BackendReadTimeout        string
+	BackendWriteTimeout       string
+	BackendKeepAliveTimeout   string
+	RequestHeaderFieldBuffer  string
+	MaxRequestHeaderFields    string
+	ResponseHeaderFieldBuffer string
+	MaxResponseHeaderFields   string
+	TLSMinProtoVersion        string
+	TLSMaxProtoVersion        string
+	Ciphers                   string
+	AccessLogFormat           string
+	// OutlierDetection, if true, appends backend information to access log format, so that the controller can observe the
+	// responses from each backend.  See EffectiveAccessLogFormat.
+	OutlierDetection bool
+	// ShareTLSTicketKey, if true, means that TLS session ticket keys are shared by the controllers, and the options which configure
+	// them are removed from ExtraConfig.
+	ShareTLSTicketKey bool
+	// ExtraConfig is the extra configurations in a format that nghttpx accepts in --conf.  The options managed by the controller are
+	// removed.
 	ExtraConfig string
+	// StrictExtraConfig, if true, allows only known safe options in ExtraConfig.
+	StrictExtraConfig bool
 	// MrubyFileContent is the extra mruby script.  It is saved in the container disk space, and will be referenced by mruby-file from
 	// configuration file.
 	MrubyFile *ChecksumFile
+	// RejectTLSMrubyFile is the mruby script which rejects the requests received via TLS.  It is referenced by the upstreams which have
+	// RejectTLS set.  It is nil if no upstream has RejectTLS set.
+	RejectTLSMrubyFile *ChecksumFile
 	// HealthPort is the port for health monitor endpoint.
 	HealthPort int
 	// APIPort is the port for API endpoint.
 	APIPort int
 	// ConfDir is the path to the directory which includes nghttpx configuration files.
 	ConfDir string
-	// HTTPPort is the port to listen to for HTTP (non-TLS) request.
-	HTTPPort int
-	// HTTPSPort is the port to listen to for HTTPS (TLS) request.
-	HTTPSPort int
+	// Frontends is the list of listeners for client connections.
+	Frontends []Frontend
+	// ListenTLSFrontendWithoutTLS, if true, makes TLS frontends listen for cleartext connections when TLS is not configured.
+	// Otherwise, TLS frontends are not opened in that case.
+	ListenTLSFrontendWithoutTLS bool
 	// FetchOCSPRespFromSecret is true if OCSP response is fetched from TLS secret.
 	FetchOCSPRespFromSecret bool
+	// ClientCACert is the CA certificate to verify client certificate.  If it is not nil, client certificate is required.
+	ClientCACert *ChecksumFile
+	// ClientCertSubjectHeader is the request header field which carries the subject name of verified client certificate to backend.
+	// If it is empty, the subject name is not forwarded.
+	ClientCertSubjectHeader string
+	// BackendCACert is the CA certificate bundle to verify backend server certificate.  nghttpx supports only one bundle, so that CA
+	// certificates referenced by all services are concatenated.
+	BackendCACert *ChecksumFile
+	// BackendClientTLSCred is the client certificate and private key which are presented to backend server.  nghttpx supports only one
+	// client certificate.
+	BackendClientTLSCred *TLSCred
+	// TLSTicketKeyFiles is the list of TLS session ticket key files.  The first key is used for encryption, and the remaining keys are
+	// used for decryption only.  If it is empty, nghttpx generates and rotates keys by itself.
+	TLSTicketKeyFiles []*ChecksumFile
 }
 
-// NewIngressConfig returns new IngressConfig.  Workers is initialized as the number of CPU cores.
+// NewIngressConfig returns new IngressConfig.  DefaultWorkers is initialized as the number of CPU cores.
 func NewIngressConfig() *IngressConfig {
 	return &IngressConfig{
-		Workers: strconv.Itoa(runtime.NumCPU()),
+		DefaultWorkers: strconv.Itoa(runtime.NumCPU()),
 	}
 }
 
+// Frontend is a listener of nghttpx for client connections.
+type Frontend struct {
+	// Host is the address to bind.  "*" means all addresses.
+	Host string
+	// Port is the port to listen to.
+	Port int
+	// TLS is true if this frontend accepts TLS connections.
+	TLS bool
+	// ProxyProto is true if this frontend expects PROXY protocol version 1 from client.
+	ProxyProto bool
+}
+
 // Upstream describes an nghttpx upstream
 type Upstream struct {
 	Name             string
@@ -82,13 +149,36 @@ type Upstream struct {
 	Path             string
 	Backends         []UpstreamServer
 	RedirectIfNotTLS bool
+	// RejectTLS, if true, rejects the requests received via TLS, because the host is not bound to any certificate.  Otherwise, the
+	// host would be served with the certificate of another host.
+	RejectTLS bool
+	// Source is the key of Ingress which this upstream is created from.  It is empty for the default backend of the controller.
+	Source string
 }
 
 type Affinity string
 
 const (
-	AffinityNone = "none"
-	AffinityIP   = "ip"
+	AffinityNone   = "none"
+	AffinityIP     = "ip"
+	AffinityCookie = "cookie"
+)
+
+// AffinityCookieSecure specifies whether secure attribute is added to the cookie for cookie based session affinity.
+type AffinityCookieSecure string
+
+const (
+	// AffinityCookieSecureAuto adds secure attribute if client connection is TLS encrypted.
+	AffinityCookieSecureAuto = "auto"
+	// AffinityCookieSecureYes always adds secure attribute.
+	AffinityCookieSecureYes = "yes"
+	// AffinityCookieSecureNo never adds secure attribute.
+	AffinityCookieSecureNo = "no"
+)
+
+const (
+	// DefaultAffinityCookieName is the default name of cookie for cookie based session affinity.
+	DefaultAffinityCookieName = "nghttpxlb"
 )
 
 type Protocol string
@@ -109,6 +199,12 @@ type UpstreamServer struct {
 	SNI      string
 	DNS      bool
 	Affinity Affinity
+	// The following fields are used only if Affinity is AffinityCookie.
+	AffinityCookieName   string
+	AffinityCookiePath   string
+	AffinityCookieSecure AffinityCookieSecure
+	// Zone is the zone of the Node where the endpoint runs.  It is only set if zone-aware routing is enabled.
+	Zone string `json:",omitempty"`
 }
 
 // TLS server private key, certificate file path, and optionally OCSP response.  OCSP response must be DER encoded byte string.
@@ -116,6 +212,9 @@ type TLSCred struct {
 	Key      ChecksumFile
 	Cert     ChecksumFile
 	OCSPResp ChecksumFile
+	// Hosts is the sorted list of host names which this key pair is associated to through Ingress .spec.tls[*].hosts.  It is empty if
+	// this key pair is not bound to the particular hosts.
+	Hosts []string
 }
 
 // NewDefaultServer return an UpstreamServer to be use as default server that returns 503.
@@ -141,11 +240,29 @@ type PortBackendConfig struct {
 	DNS bool `json:"dns,omitempty"`
 	// Affinity is session affinity method nghttpx supports.  See affinity parameter in backend option of nghttpx.
 	Affinity Affinity `json:"affinity,omitempty"`
+	// AffinityCookieName is the name of cookie for cookie based session affinity.  It is used only if Affinity is AffinityCookie.
+	AffinityCookieName string `json:"affinityCookieName,omitempty"`
+	// AffinityCookiePath is the path attribute of cookie for cookie based session affinity.  It is used only if Affinity is
+	// AffinityCookie.
+	AffinityCookiePath string `json:"affinityCookiePath,omitempty"`
+	// AffinityCookieSecure specifies whether secure attribute is added to cookie for cookie based session affinity.  It is used only
+	// if Affinity is AffinityCookie.
+	AffinityCookieSecure AffinityCookieSecure `json:"affinityCookieSecure,omitempty"`
+	// CASecret is the name of Secret in the same namespace which contains CA certificate bundle under "ca.crt" key to verify backend
+	// server certificate.
+	CASecret string `json:"caSecret,omitempty"`
+	// ClientSecret is the name of Secret in the same namespace which contains client certificate and private key under "tls.crt" and
+	// "tls.key" keys, which are presented to backend server.
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// ClusterIP, if true, routes requests to ClusterIP and port of Service rather than its endpoints.  If Service has no ClusterIP,
+	// its endpoints are used.
+	ClusterIP bool `json:"clusterIP,omitempty"`
 }
 
 // ChecksumFile represents a file with path, its arbitrary content, and its checksum.
 type ChecksumFile struct {
-	Path     string
-	Content  []byte
+	Path string
+	// Content is not serialized to JSON because it might contain a private key.
+	Content  []byte `json:"-"`
 	Checksum string
 }
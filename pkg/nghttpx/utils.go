@@ -28,13 +28,20 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -43,19 +50,613 @@ const (
 	NghttpxExtraConfigKey = "nghttpx-conf"
 	// NghttpxMrubyFileContentKey is a field name of mruby script in ConfigMap.
 	NghttpxMrubyFileContentKey = "nghttpx-mruby-file-content"
+	// NghttpxClientCASecretKey is a field name of the Secret, in the form of namespace/name, which contains CA certificate to verify
+	// client certificate.
+	NghttpxClientCASecretKey = "client-ca-secret"
+	// NghttpxClientCertSubjectHeaderKey is a field name of the request header field which carries the subject name of verified client
+	// certificate to backend.
+	NghttpxClientCertSubjectHeaderKey = "client-cert-subject-header"
+
+	// The following keys are typed nghttpx settings in ConfigMap.  They are named after the corresponding nghttpx options, and take
+	// precedence over the options in NghttpxExtraConfigKey.
+
+	// NghttpxWorkersKey is a field name of the number of worker threads.
+	NghttpxWorkersKey = "workers"
+	// NghttpxFrontendReadTimeoutKey is a field name of read timeout for frontend connection.
+	NghttpxFrontendReadTimeoutKey = "frontend-read-timeout"
+	// NghttpxFrontendWriteTimeoutKey is a field name of write timeout for frontend connection.
+	NghttpxFrontendWriteTimeoutKey = "frontend-write-timeout"
+	// NghttpxFrontendKeepAliveTimeoutKey is a field name of keep-alive timeout for frontend HTTP/1 connection.
+	NghttpxFrontendKeepAliveTimeoutKey = "frontend-keep-alive-timeout"
+	// NghttpxBackendReadTimeoutKey is a field name of read timeout for backend connection.
+	NghttpxBackendReadTimeoutKey = "backend-read-timeout"
+	// NghttpxBackendWriteTimeoutKey is a field name of write timeout for backend connection.
+	NghttpxBackendWriteTimeoutKey = "backend-write-timeout"
+	// NghttpxBackendKeepAliveTimeoutKey is a field name of keep-alive timeout for backend HTTP/1 connection.
+	NghttpxBackendKeepAliveTimeoutKey = "backend-keep-alive-timeout"
+	// NghttpxRequestHeaderFieldBufferKey is a field name of the maximum buffer size for request header fields.
+	NghttpxRequestHeaderFieldBufferKey = "request-header-field-buffer"
+	// NghttpxMaxRequestHeaderFieldsKey is a field name of the maximum number of request header fields.
+	NghttpxMaxRequestHeaderFieldsKey = "max-request-header-fields"
+	// NghttpxResponseHeaderFieldBufferKey is a field name of the maximum buffer size for response header fields.
+	NghttpxResponseHeaderFieldBufferKey = "response-header-field-buffer"
+	// NghttpxMaxResponseHeaderFieldsKey is a field name of the maximum number of response header fields.
+	NghttpxMaxResponseHeaderFieldsKey = "max-response-header-fields"
+	// NghttpxTLSMinProtoVersionKey is a field name of the minimum TLS protocol version.
+	NghttpxTLSMinProtoVersionKey = "tls-min-proto-version"
+	// NghttpxTLSMaxProtoVersionKey is a field name of the maximum TLS protocol version.
+	NghttpxTLSMaxProtoVersionKey = "tls-max-proto-version"
+	// NghttpxCiphersKey is a field name of the list of TLS cipher suites in OpenSSL format.
+	NghttpxCiphersKey = "ciphers"
+	// NghttpxAccessLogFormatKey is a field name of the access log format.
+	NghttpxAccessLogFormatKey = "accesslog-format"
+	// NghttpxFrontendsKey is a field name of the list of frontends.  Each line describes a frontend in the form that ParseFrontend
+	// accepts.  It replaces the frontends specified by command-line flags.
+	NghttpxFrontendsKey = "frontends"
+
+	// DefaultClientCertSubjectHeader is the default request header field which carries the subject name of verified client
+	// certificate.
+	DefaultClientCertSubjectHeader = "x-client-cert-subject"
 )
 
-// ReadConfig obtains the configuration defined by the user merged with the defaults.
-func ReadConfig(ingConfig *IngressConfig, config *v1.ConfigMap) {
-	ingConfig.ExtraConfig = config.Data[NghttpxExtraConfigKey]
-	if mrubyFileContent, ok := config.Data[NghttpxMrubyFileContentKey]; ok {
-		b := []byte(mrubyFileContent)
+var (
+	// headerFieldNameRegexp matches lower-cased HTTP header field name.  It only allows the subset of token characters so that the name
+	// can be safely embedded in mruby string literal.
+	headerFieldNameRegexp = regexp.MustCompile("^[0-9a-z_-]+$")
+	// durationRegexp matches duration that nghttpx accepts.  No unit means seconds.
+	durationRegexp = regexp.MustCompile("^[0-9]+(h|m|s|ms)?$")
+	// sizeRegexp matches size that nghttpx accepts.
+	sizeRegexp = regexp.MustCompile("^[0-9]+[KMG]?$")
+	// cookieNameRegexp matches cookie name, which is a token defined in RFC 7230.
+	cookieNameRegexp = regexp.MustCompile("^[0-9A-Za-z!#$%&'*+.^_`|~-]+$")
+	// cookiePathRegexp matches cookie path attribute.  It excludes ";" which is the parameter separator of backend option.
+	cookiePathRegexp = regexp.MustCompile(`^/[\x21-\x3a\x3c-\x7e]*$`)
+	// ciphersRegexp matches OpenSSL cipher list.
+	ciphersRegexp = regexp.MustCompile("^[0-9A-Za-z_:+!@=.,-]+$")
+
+	// controllerOwnedOptions is the set of nghttpx options which the controller manages.  They are removed from
+	// NghttpxExtraConfigKey because overriding them breaks the controller, for example, the API endpoint which the controller relies
+	// on.
+	controllerOwnedOptions = map[string]string{
+		"frontend":                 "frontends are configured by the controller",
+		"backend":                  "backends are configured by Ingress resources",
+		"include":                  "it could include any controller managed options",
+		"conf":                     "it could include any controller managed options",
+		"daemon":                   "the controller manages nghttpx process",
+		"pid-file":                 "the controller manages nghttpx process",
+		"user":                     "the controller manages nghttpx process",
+		"workers":                  fmt.Sprintf("use %v key instead", NghttpxWorkersKey),
+		"private-key-file":         "TLS certificates are configured by Ingress resources",
+		"certificate-file":         "TLS certificates are configured by Ingress resources",
+		"subcert":                  "TLS certificates are configured by Ingress resources",
+		"verify-client":            fmt.Sprintf("use %v key instead", NghttpxClientCASecretKey),
+		"verify-client-cacert":     fmt.Sprintf("use %v key instead", NghttpxClientCASecretKey),
+		"cacert":                   "backend CA certificate is configured by Ingress resources",
+		"client-private-key-file":  "backend client certificate is configured by Ingress resources",
+		"client-cert-file":         "backend client certificate is configured by Ingress resources",
+		"mruby-file":               fmt.Sprintf("use %v key instead", NghttpxMrubyFileContentKey),
+		"fetch-ocsp-response-file": "OCSP response is managed by the controller",
+	}
+
+	// outlierDetectionOwnedOptions is the set of nghttpx options which are removed from NghttpxExtraConfigKey while OutlierDetection is
+	// enabled.  Outlier detection reads access log from the standard output of nghttpx, and these options redirect it elsewhere.
+	outlierDetectionOwnedOptions = map[string]string{
+		"accesslog-file":   "outlier detection reads access log from the standard output of nghttpx",
+		"accesslog-syslog": "outlier detection reads access log from the standard output of nghttpx",
+	}
+
+	// tlsTicketKeyOwnedOptionPrefixes is the prefixes of nghttpx options which are removed from NghttpxExtraConfigKey while
+	// ShareTLSTicketKey is enabled.  The controller manages TLS session ticket keys, and these options configure them in other ways.
+	tlsTicketKeyOwnedOptionPrefixes = map[string]string{
+		"tls-ticket-key-": "TLS session ticket keys are shared by the controllers",
+	}
+
+	// allowedOptions is the set of nghttpx options which are allowed in NghttpxExtraConfigKey in strict mode.
+	allowedOptions = map[string]bool{
+		"log-level":                           true,
+		"accesslog-file":                      true,
+		"accesslog-syslog":                    true,
+		"accesslog-format":                    true,
+		"errorlog-file":                       true,
+		"errorlog-syslog":                     true,
+		"add-x-forwarded-for":                 true,
+		"strip-incoming-x-forwarded-for":      true,
+		"no-add-x-forwarded-proto":            true,
+		"no-strip-incoming-x-forwarded-proto": true,
+		"add-forwarded":                       true,
+		"strip-incoming-forwarded":            true,
+		"forwarded-by":                        true,
+		"forwarded-for":                       true,
+		"no-via":                              true,
+		"add-request-header":                  true,
+		"add-response-header":                 true,
+		"server-name":                         true,
+		"no-server-rewrite":                   true,
+		"no-location-rewrite":                 true,
+		"no-server-push":                      true,
+		"ciphers":                             true,
+		"ecdh-curves":                         true,
+		"no-ocsp":                             true,
+		"ocsp-update-interval":                true,
+		"backlog":                             true,
+		"request-header-field-buffer":         true,
+		"max-request-header-fields":           true,
+		"response-header-field-buffer":        true,
+		"max-response-header-fields":          true,
+		"rlimit-nofile":                       true,
+		"worker-read-rate":                    true,
+		"worker-read-burst":                   true,
+		"worker-write-rate":                   true,
+		"worker-write-burst":                  true,
+		"worker-frontend-connections":         true,
+		"stream-read-timeout":                 true,
+		"stream-write-timeout":                true,
+		"listener-disable-timeout":            true,
+		"frontend-read-timeout":               true,
+		"frontend-write-timeout":              true,
+		"frontend-keep-alive-timeout":         true,
+		"backend-read-timeout":                true,
+		"backend-write-timeout":               true,
+		"backend-keep-alive-timeout":          true,
+		"backend-connections-per-host":        true,
+		"backend-connections-per-frontend":    true,
+		"backend-max-backoff":                 true,
+		"backend-request-buffer":              true,
+		"backend-response-buffer":             true,
+		"dns-cache-timeout":                   true,
+		"dns-lookup-timeout":                  true,
+		"dns-max-try":                         true,
+	}
+
+	// allowedOptionPrefixes is the list of prefixes of nghttpx options which are allowed in NghttpxExtraConfigKey in strict mode.
+	allowedOptionPrefixes = []string{
+		"frontend-http2-",
+		"backend-http2-",
+		"http2-",
+		"tls-",
+	}
+
+	// tlsProtoVersions is the list of TLS protocol versions that nghttpx accepts in the ascending order.
+	tlsProtoVersions = []string{"TLSv1.0", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+)
+
+// ReadConfig obtains the configuration defined by the user merged with the defaults.  If ingConfig.ClientCACert is not nil, ReadConfig must
+// be called after it is set, because the generated mruby script depends on it.  It returns an error which describes the invalid values
+// in config.  Those values are ignored, and the other values are still applied.
+func ReadConfig(ingConfig *IngressConfig, config *v1.ConfigMap) error {
+	var errs []error
+
+	extraConfig, extraConfigErrs := filterExtraConfig(config.Data[NghttpxExtraConfigKey], ingConfig.StrictExtraConfig,
+		ingConfig.OutlierDetection, ingConfig.ShareTLSTicketKey)
+	ingConfig.ExtraConfig = extraConfig
+	errs = append(errs, extraConfigErrs...)
+
+	for _, opt := range typedOptions(ingConfig) {
+		s, ok := config.Data[opt.key]
+		if !ok {
+			continue
+		}
+		v, err := opt.parse(strings.TrimSpace(s))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", opt.key, err))
+			continue
+		}
+		*opt.dst = v
+	}
+
+	if s, ok := config.Data[NghttpxFrontendsKey]; ok {
+		if frontends, err := ParseFrontends(s); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", NghttpxFrontendsKey, err))
+		} else {
+			ingConfig.Frontends = frontends
+		}
+	}
+
+	if ingConfig.TLSMinProtoVersion != "" && ingConfig.TLSMaxProtoVersion != "" &&
+		tlsProtoVersionIndex(ingConfig.TLSMinProtoVersion) > tlsProtoVersionIndex(ingConfig.TLSMaxProtoVersion) {
+		errs = append(errs, fmt.Errorf("%v: %v is greater than %v %v", NghttpxTLSMinProtoVersionKey, ingConfig.TLSMinProtoVersion,
+			NghttpxTLSMaxProtoVersionKey, ingConfig.TLSMaxProtoVersion))
+		ingConfig.TLSMinProtoVersion = ""
+		ingConfig.TLSMaxProtoVersion = ""
+	}
+
+	var mrubyFileContent []byte
+	if s, ok := config.Data[NghttpxMrubyFileContentKey]; ok {
+		mrubyFileContent = []byte(s)
+	}
+
+	if ingConfig.ClientCACert != nil {
+		header := DefaultClientCertSubjectHeader
+		if s, ok := config.Data[NghttpxClientCertSubjectHeaderKey]; ok {
+			header = strings.ToLower(strings.TrimSpace(s))
+		}
+		switch {
+		case header == "":
+			// Forwarding client certificate subject is disabled.
+		case !headerFieldNameRegexp.MatchString(header):
+			errs = append(errs, fmt.Errorf("%v: invalid header field name %q; client certificate subject is not forwarded",
+				NghttpxClientCertSubjectHeaderKey, header))
+		default:
+			ingConfig.ClientCertSubjectHeader = header
+			mrubyFileContent = clientCertMrubyScript(header, mrubyFileContent)
+		}
+	}
+
+	if mrubyFileContent != nil {
 		ingConfig.MrubyFile = &ChecksumFile{
 			Path:     NghttpxMrubyRbPath(ingConfig.ConfDir),
-			Content:  b,
-			Checksum: Checksum(b),
+			Content:  mrubyFileContent,
+			Checksum: Checksum(mrubyFileContent),
 		}
 	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// typedOption is an nghttpx option which is configured by its own key in ConfigMap.
+type typedOption struct {
+	// key is the key in ConfigMap.  It is also the name of nghttpx option.
+	key string
+	// dst is the field of IngressConfig which stores the parsed value.
+	dst *string
+	// parse validates a value, and returns it in the form which nghttpx accepts.
+	parse func(string) (string, error)
+}
+
+// typedOptions returns the list of typedOption whose value is stored in ingConfig.
+func typedOptions(ingConfig *IngressConfig) []typedOption {
+	return []typedOption{
+		{NghttpxWorkersKey, &ingConfig.Workers, parsePositiveInteger},
+		{NghttpxFrontendReadTimeoutKey, &ingConfig.FrontendReadTimeout, parseDuration},
+		{NghttpxFrontendWriteTimeoutKey, &ingConfig.FrontendWriteTimeout, parseDuration},
+		{NghttpxFrontendKeepAliveTimeoutKey, &ingConfig.FrontendKeepAliveTimeout, parseDuration},
+		{NghttpxBackendReadTimeoutKey, &ingConfig.BackendReadTimeout, parseDuration},
+		{NghttpxBackendWriteTimeoutKey, &ingConfig.BackendWriteTimeout, parseDuration},
+		{NghttpxBackendKeepAliveTimeoutKey, &ingConfig.BackendKeepAliveTimeout, parseDuration},
+		{NghttpxRequestHeaderFieldBufferKey, &ingConfig.RequestHeaderFieldBuffer, parseSize},
+		{NghttpxMaxRequestHeaderFieldsKey, &ingConfig.MaxRequestHeaderFields, parsePositiveInteger},
+		{NghttpxResponseHeaderFieldBufferKey, &ingConfig.ResponseHeaderFieldBuffer, parseSize},
+		{NghttpxMaxResponseHeaderFieldsKey, &ingConfig.MaxResponseHeaderFields, parsePositiveInteger},
+		{NghttpxTLSMinProtoVersionKey, &ingConfig.TLSMinProtoVersion, parseTLSProtoVersion},
+		{NghttpxTLSMaxProtoVersionKey, &ingConfig.TLSMaxProtoVersion, parseTLSProtoVersion},
+		{NghttpxCiphersKey, &ingConfig.Ciphers, parseCiphers},
+		{NghttpxAccessLogFormatKey, &ingConfig.AccessLogFormat, parseSingleLine},
+	}
+}
+
+// RemoveUnsupportedOptions removes the settings from ingConfig which nghttpx described by caps does not support.  It returns the error
+// which describes the removed settings read from ConfigMap.  The features which are not configured by ConfigMap, such as outlier
+// detection, are disabled silently, because they do not change after the controller starts, and the controller reports them once.
+func RemoveUnsupportedOptions(ingConfig *IngressConfig, caps *Capabilities) error {
+	var errs []error
+
+	for _, opt := range typedOptions(ingConfig) {
+		if *opt.dst == "" || caps.HasOption(opt.key) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%v: nghttpx %v does not support this option", opt.key, caps.Version))
+		*opt.dst = ""
+	}
+
+	if !caps.ProxyProto {
+		// ingConfig.Frontends might be shared with the controller, so that it must not be modified in place.
+		var frontends []Frontend
+		for _, fe := range ingConfig.Frontends {
+			if fe.ProxyProto {
+				errs = append(errs, fmt.Errorf("%v: %v,%v: nghttpx %v does not support PROXY protocol", NghttpxFrontendsKey, fe.Host,
+					fe.Port, caps.Version))
+				fe.ProxyProto = false
+			}
+			frontends = append(frontends, fe)
+		}
+		ingConfig.Frontends = frontends
+	}
+
+	if ingConfig.OutlierDetection && !caps.BackendLogVariables {
+		ingConfig.OutlierDetection = false
+	}
+
+	if ingConfig.ClientCertSubjectHeader != "" && !caps.MrubyTLSClientCert {
+		// The generated mruby script still removes the header field sent by client.
+		errs = append(errs, fmt.Errorf("%v: nghttpx %v does not provide client certificate to mruby; %v is removed, but not set",
+			NghttpxClientCertSubjectHeaderKey, caps.Version, ingConfig.ClientCertSubjectHeader))
+		ingConfig.ClientCertSubjectHeader = ""
+	}
+
+	if ingConfig.RejectTLSMrubyFile != nil && !caps.PerPatternMruby {
+		// ingConfig.Upstreams might be shared with the controller, so that they must not be modified in place.
+		var upstreams []*Upstream
+		for _, ups := range ingConfig.Upstreams {
+			if ups.RejectTLS {
+				u := *ups
+				u.RejectTLS = false
+				ups = &u
+			}
+			upstreams = append(upstreams, ups)
+		}
+		ingConfig.Upstreams = upstreams
+		ingConfig.RejectTLSMrubyFile = nil
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// filterExtraConfig removes the options from extraConfig which the controller manages.  If strict is true, it also removes the options
+// which are not allowed explicitly.  If outlierDetection is true, it also removes the options which prevent outlier detection from
+// reading access log.  If shareTLSTicketKey is true, it also removes the options which configure TLS session ticket keys.  It returns the
+// filtered configuration, and the list of errors which describe the removed lines.
+func filterExtraConfig(extraConfig string, strict, outlierDetection, shareTLSTicketKey bool) (string, []error) {
+	if extraConfig == "" {
+		return "", nil
+	}
+
+	var (
+		lines []string
+		errs  []error
+	)
+
+	for i, line := range strings.Split(extraConfig, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, line)
+			continue
+		}
+
+		eq := strings.Index(trimmed, "=")
+		if eq == -1 {
+			if strict {
+				errs = append(errs, fmt.Errorf("%v: line %v: %q is not in the form of name=value, and ignored",
+					NghttpxExtraConfigKey, i+1, trimmed))
+				continue
+			}
+			// Leave it to nghttpx in permissive mode.
+			lines = append(lines, line)
+			continue
+		}
+
+		name := strings.TrimSpace(trimmed[:eq])
+		if reason, ok := controllerOwnedOptions[name]; ok {
+			errs = append(errs, fmt.Errorf("%v: line %v: %v is managed by the controller, and ignored; %v", NghttpxExtraConfigKey, i+1,
+				name, reason))
+			continue
+		}
+
+		if reason, ok := outlierDetectionOwnedOptions[name]; ok && outlierDetection {
+			errs = append(errs, fmt.Errorf("%v: line %v: %v is ignored while outlier detection is enabled; %v", NghttpxExtraConfigKey,
+				i+1, name, reason))
+			continue
+		}
+
+		if reason, ok := tlsTicketKeyOwnedOption(name); ok && shareTLSTicketKey {
+			errs = append(errs, fmt.Errorf("%v: line %v: %v is ignored while TLS session ticket keys are shared; %v",
+				NghttpxExtraConfigKey, i+1, name, reason))
+			continue
+		}
+
+		if strict && !optionAllowed(name) {
+			errs = append(errs, fmt.Errorf("%v: line %v: %v is not allowed in strict mode, and ignored", NghttpxExtraConfigKey, i+1,
+				name))
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), errs
+}
+
+// tlsTicketKeyOwnedOption returns true if nghttpx option name configures TLS session ticket keys.  It also returns the reason why it is
+// removed while the keys are shared.
+func tlsTicketKeyOwnedOption(name string) (string, bool) {
+	for prefix, reason := range tlsTicketKeyOwnedOptionPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// optionAllowed returns true if nghttpx option name is allowed in NghttpxExtraConfigKey in strict mode.
+func optionAllowed(name string) bool {
+	if allowedOptions[name] {
+		return true
+	}
+	for _, prefix := range allowedOptionPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFrontend parses s as a frontend.  The syntax is similar to frontend option of nghttpx: <HOST>,<PORT>[;tls][;proxyproto].  HOST is
+// "*" or IP address.  "tls" makes the frontend accept TLS connections.  "proxyproto" makes the frontend expect PROXY protocol.
+func ParseFrontend(s string) (Frontend, error) {
+	var fe Frontend
+
+	params := strings.Split(strings.TrimSpace(s), ";")
+
+	addr := params[0]
+	comma := strings.LastIndex(addr, ",")
+	if comma == -1 {
+		return fe, fmt.Errorf("%q: address must be in the form of <HOST>,<PORT>", s)
+	}
+	fe.Host = strings.TrimSpace(addr[:comma])
+	if fe.Host != "*" && net.ParseIP(fe.Host) == nil {
+		return fe, fmt.Errorf("%q: host must be * or IP address", s)
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(addr[comma+1:]))
+	if err != nil || port <= 0 || port > 65535 {
+		return fe, fmt.Errorf("%q: invalid port", s)
+	}
+	fe.Port = port
+
+	for _, param := range params[1:] {
+		switch strings.TrimSpace(param) {
+		case "tls":
+			fe.TLS = true
+		case "proxyproto":
+			fe.ProxyProto = true
+		case "":
+		default:
+			return fe, fmt.Errorf("%q: unknown parameter %q", s, param)
+		}
+	}
+
+	return fe, nil
+}
+
+// ParseFrontends parses s which contains a frontend per line.  Empty lines are ignored.  It returns an error if no frontend is found, or
+// the same address appears more than once.
+func ParseFrontends(s string) ([]Frontend, error) {
+	var frontends []Frontend
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fe, err := ParseFrontend(line)
+		if err != nil {
+			return nil, err
+		}
+		for i := range frontends {
+			if frontends[i].Host == fe.Host && frontends[i].Port == fe.Port {
+				return nil, fmt.Errorf("%v,%v appears more than once", fe.Host, fe.Port)
+			}
+		}
+		frontends = append(frontends, fe)
+	}
+	if len(frontends) == 0 {
+		return nil, errors.New("no frontend is specified")
+	}
+	return frontends, nil
+}
+
+// parsePositiveInteger returns s if it is a positive integer.
+func parsePositiveInteger(s string) (string, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("%q is not a positive integer", s)
+	}
+	return strconv.Itoa(n), nil
+}
+
+// parseDuration returns s if it is a duration that nghttpx accepts, such as 30s, or 1m.
+func parseDuration(s string) (string, error) {
+	if !durationRegexp.MatchString(s) {
+		return "", fmt.Errorf("%q is not a valid duration; it must be an integer optionally followed by h, m, s, or ms", s)
+	}
+	return s, nil
+}
+
+// parseSize returns s if it is a size that nghttpx accepts, such as 64K.
+func parseSize(s string) (string, error) {
+	if !sizeRegexp.MatchString(s) {
+		return "", fmt.Errorf("%q is not a valid size; it must be an integer optionally followed by K, M, or G", s)
+	}
+	return s, nil
+}
+
+// parseTLSProtoVersion returns the canonical form of s if it is a TLS protocol version that nghttpx accepts.  It is case-insensitive.
+func parseTLSProtoVersion(s string) (string, error) {
+	i := tlsProtoVersionIndex(s)
+	if i == -1 {
+		return "", fmt.Errorf("%q is not a valid TLS protocol version; it must be one of %v", s, strings.Join(tlsProtoVersions, ", "))
+	}
+	return tlsProtoVersions[i], nil
+}
+
+// tlsProtoVersionIndex returns the index of v in tlsProtoVersions.  It returns -1 if v is not found.
+func tlsProtoVersionIndex(v string) int {
+	for i, ver := range tlsProtoVersions {
+		if strings.EqualFold(v, ver) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCiphers returns s if it looks like OpenSSL cipher list.
+func parseCiphers(s string) (string, error) {
+	if !ciphersRegexp.MatchString(s) {
+		return "", fmt.Errorf("%q is not a valid cipher list", s)
+	}
+	return s, nil
+}
+
+// parseSingleLine returns s if it is not empty, and does not contain line break, which would inject another option into the
+// configuration file.
+func parseSingleLine(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("value must not be empty")
+	}
+	if strings.ContainsAny(s, "\r\n") {
+		return "", errors.New("value must not contain line break")
+	}
+	return s, nil
+}
+
+// clientCertMrubyScript returns mruby script which sets the subject name of verified client certificate to request header field header.
+// The header field sent by client is always removed so that it cannot be spoofed.  If userScript is not nil, it is evaluated first, and
+// the resulting object is chained.
+func clientCertMrubyScript(header string, userScript []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Generated by nghttpx Ingress controller\n")
+	if userScript != nil {
+		buf.WriteString("nghttpx_ingress_user_app = begin\n")
+		buf.Write(userScript)
+		buf.WriteString("\nend\n\n")
+	} else {
+		buf.WriteString("nghttpx_ingress_user_app = nil\n\n")
+	}
+	fmt.Fprintf(&buf, `class NghttpxIngressClientCert
+  def initialize(app)
+    @app = app
+  end
+
+  def on_req(env)
+    subject = env.tls_used && env.respond_to?(:tls_client_subject_name) ? env.tls_client_subject_name : ""
+    if subject.nil? || subject.empty?
+      env.req.set_header "%v", []
+    else
+      env.req.set_header "%v", subject
+    end
+    @app.on_req(env) if @app.respond_to?(:on_req)
+  end
+
+  def on_resp(env)
+    @app.on_resp(env) if @app.respond_to?(:on_resp)
+  end
+end
+
+NghttpxIngressClientCert.new(nghttpx_ingress_user_app)
+`, header, header)
+
+	return buf.Bytes()
+}
+
+// rejectTLSMrubyScript is the per-pattern mruby script which responds with 421 Misdirected Request if the request is received via TLS.
+const rejectTLSMrubyScript = `# Generated by nghttpx Ingress controller
+class NghttpxIngressRejectTLS
+  def on_req(env)
+    if env.tls_used
+      env.resp.status = 421
+      env.resp.return "Misdirected Request"
+    end
+  end
+end
+
+NghttpxIngressRejectTLS.new
+`
+
+// CreateRejectTLSMrubyFile returns the mruby script file under dir which rejects the requests received via TLS.
+func CreateRejectTLSMrubyFile(dir string) *ChecksumFile {
+	content := []byte(rejectTLSMrubyScript)
+	return &ChecksumFile{
+		Path:     NghttpxRejectTLSMrubyRbPath(dir),
+		Content:  content,
+		Checksum: Checksum(content),
+	}
 }
 
 // needsReload first checks that configuration is changed.  filename
@@ -139,12 +740,35 @@ func FixupPortBackendConfig(config PortBackendConfig, svc, port string) PortBack
 	switch config.Affinity {
 	case AffinityNone, AffinityIP:
 		// OK
+	case AffinityCookie:
+		if config.AffinityCookieName == "" {
+			config.AffinityCookieName = DefaultAffinityCookieName
+		} else if !cookieNameRegexp.MatchString(config.AffinityCookieName) {
+			glog.Errorf("invalid affinity cookie name %q for service %v, port %v", config.AffinityCookieName, svc, port)
+			config.AffinityCookieName = DefaultAffinityCookieName
+		}
+		if config.AffinityCookiePath != "" && !cookiePathRegexp.MatchString(config.AffinityCookiePath) {
+			glog.Errorf("invalid affinity cookie path %q for service %v, port %v", config.AffinityCookiePath, svc, port)
+			config.AffinityCookiePath = ""
+		}
+		switch config.AffinityCookieSecure {
+		case "", AffinityCookieSecureAuto, AffinityCookieSecureYes, AffinityCookieSecureNo:
+			// OK
+		default:
+			glog.Errorf("invalid affinity cookie secure %v for service %v, port %v", config.AffinityCookieSecure, svc, port)
+			config.AffinityCookieSecure = ""
+		}
 	case "":
 		config.Affinity = AffinityNone
 	default:
 		glog.Errorf("unsupported affinity method %v for service %v, port %v", config.Affinity, svc, port)
 		config.Affinity = AffinityNone
 	}
+	if config.Affinity != AffinityCookie {
+		config.AffinityCookieName = ""
+		config.AffinityCookiePath = ""
+		config.AffinityCookieSecure = ""
+	}
 	return config
 }
 
@@ -193,6 +817,11 @@ func NghttpxBackendConfigPath(dir string) string {
 	return filepath.Join(dir, "nghttpx-backend.conf")
 }
 
+// NghttpxRejectTLSMrubyRbPath returns the path to the mruby script file which rejects the requests received via TLS.
+func NghttpxRejectTLSMrubyRbPath(dir string) string {
+	return filepath.Join(dir, "reject-tls.rb")
+}
+
 // NghttpxMrubyRbPath returns the path to nghttpx mruby.rb file.
 func NghttpxMrubyRbPath(dir string) string {
 	return filepath.Join(dir, "mruby.rb")
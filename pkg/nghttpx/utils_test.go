@@ -9,7 +9,12 @@
 package nghttpx
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/api/v1"
 )
 
 // TestFixupPortBackendConfig validates fixupPortBackendConfig corrects invalid input to the correct default value.
@@ -46,6 +51,62 @@ func TestFixupPortBackendConfig(t *testing.T) {
 				Affinity: AffinityIP,
 			},
 		},
+		{
+			// Cookie name defaults to DefaultAffinityCookieName.
+			in: PortBackendConfig{
+				Proto:    ProtocolH1,
+				Affinity: AffinityCookie,
+			},
+			out: PortBackendConfig{
+				Proto:              ProtocolH1,
+				Affinity:           AffinityCookie,
+				AffinityCookieName: DefaultAffinityCookieName,
+			},
+		},
+		{
+			// Correct cookie parameters must be left unchanged.
+			in: PortBackendConfig{
+				Proto:                ProtocolH1,
+				Affinity:             AffinityCookie,
+				AffinityCookieName:   "session",
+				AffinityCookiePath:   "/app",
+				AffinityCookieSecure: AffinityCookieSecureYes,
+			},
+			out: PortBackendConfig{
+				Proto:                ProtocolH1,
+				Affinity:             AffinityCookie,
+				AffinityCookieName:   "session",
+				AffinityCookiePath:   "/app",
+				AffinityCookieSecure: AffinityCookieSecureYes,
+			},
+		},
+		{
+			// Invalid cookie parameters must be fixed.
+			in: PortBackendConfig{
+				Proto:                ProtocolH1,
+				Affinity:             AffinityCookie,
+				AffinityCookieName:   "bad name",
+				AffinityCookiePath:   "/;affinity=ip",
+				AffinityCookieSecure: "maybe",
+			},
+			out: PortBackendConfig{
+				Proto:              ProtocolH1,
+				Affinity:           AffinityCookie,
+				AffinityCookieName: DefaultAffinityCookieName,
+			},
+		},
+		{
+			// Cookie parameters must be cleared if affinity is not cookie.
+			in: PortBackendConfig{
+				Proto:              ProtocolH1,
+				Affinity:           AffinityIP,
+				AffinityCookieName: "session",
+			},
+			out: PortBackendConfig{
+				Proto:    ProtocolH1,
+				Affinity: AffinityIP,
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -54,3 +115,377 @@ func TestFixupPortBackendConfig(t *testing.T) {
 		}
 	}
 }
+
+// TestReadConfigClientCert verifies that ReadConfig generates mruby script to forward client certificate subject.
+func TestReadConfigClientCert(t *testing.T) {
+	const userScript = "App.new"
+
+	tests := []struct {
+		data       map[string]string
+		wantHeader string
+		wantMruby  bool
+	}{
+		{
+			wantHeader: DefaultClientCertSubjectHeader,
+			wantMruby:  true,
+		},
+		{
+			data: map[string]string{
+				NghttpxClientCertSubjectHeaderKey: "X-Subject",
+				NghttpxMrubyFileContentKey:        userScript,
+			},
+			wantHeader: "x-subject",
+			wantMruby:  true,
+		},
+		{
+			data: map[string]string{
+				NghttpxClientCertSubjectHeaderKey: "",
+			},
+		},
+		{
+			data: map[string]string{
+				NghttpxClientCertSubjectHeaderKey: "bad header",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		ingConfig := NewIngressConfig()
+		ingConfig.ClientCACert = &ChecksumFile{}
+
+		ReadConfig(ingConfig, &v1.ConfigMap{Data: tt.data})
+
+		if got, want := ingConfig.ClientCertSubjectHeader, tt.wantHeader; got != want {
+			t.Errorf("#%v: ingConfig.ClientCertSubjectHeader = %q, want %q", i, got, want)
+		}
+		if got, want := ingConfig.MrubyFile != nil, tt.wantMruby; got != want {
+			t.Errorf("#%v: ingConfig.MrubyFile != nil = %v, want %v", i, got, want)
+			continue
+		}
+		if ingConfig.MrubyFile == nil {
+			continue
+		}
+		content := string(ingConfig.MrubyFile.Content)
+		if !strings.Contains(content, `"`+tt.wantHeader+`"`) {
+			t.Errorf("#%v: mruby script does not contain header %v:\n%v", i, tt.wantHeader, content)
+		}
+		if _, ok := tt.data[NghttpxMrubyFileContentKey]; ok && !strings.Contains(content, userScript) {
+			t.Errorf("#%v: mruby script does not contain user script:\n%v", i, content)
+		}
+	}
+}
+
+// TestReadConfigTypedSettings verifies that ReadConfig parses typed settings, and reports invalid values.
+func TestReadConfigTypedSettings(t *testing.T) {
+	tests := []struct {
+		desc    string
+		data    map[string]string
+		want    IngressConfig
+		wantErr bool
+	}{
+		{
+			desc: "valid values",
+			data: map[string]string{
+				NghttpxWorkersKey:                   "4",
+				NghttpxFrontendReadTimeoutKey:       "1m",
+				NghttpxFrontendWriteTimeoutKey:      "30",
+				NghttpxFrontendKeepAliveTimeoutKey:  "500ms",
+				NghttpxBackendReadTimeoutKey:        "2h",
+				NghttpxBackendWriteTimeoutKey:       "30s",
+				NghttpxBackendKeepAliveTimeoutKey:   "2s",
+				NghttpxRequestHeaderFieldBufferKey:  "64K",
+				NghttpxMaxRequestHeaderFieldsKey:    "100",
+				NghttpxResponseHeaderFieldBufferKey: "1M",
+				NghttpxMaxResponseHeaderFieldsKey:   "200",
+				NghttpxTLSMinProtoVersionKey:        "tlsv1.2",
+				NghttpxTLSMaxProtoVersionKey:        "TLSv1.3",
+				NghttpxCiphersKey:                   "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256",
+				NghttpxAccessLogFormatKey:           ` $remote_addr "$request" $status `,
+			},
+			want: IngressConfig{
+				Workers:                   "4",
+				FrontendReadTimeout:       "1m",
+				FrontendWriteTimeout:      "30",
+				FrontendKeepAliveTimeout:  "500ms",
+				BackendReadTimeout:        "2h",
+				BackendWriteTimeout:       "30s",
+				BackendKeepAliveTimeout:   "2s",
+				RequestHeaderFieldBuffer:  "64K",
+				MaxRequestHeaderFields:    "100",
+				ResponseHeaderFieldBuffer: "1M",
+				MaxResponseHeaderFields:   "200",
+				TLSMinProtoVersion:        "TLSv1.2",
+				TLSMaxProtoVersion:        "TLSv1.3",
+				Ciphers:                   "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256",
+				AccessLogFormat:           `$remote_addr "$request" $status`,
+			},
+		},
+		{
+			desc: "invalid values are ignored, and valid ones are applied",
+			data: map[string]string{
+				NghttpxWorkersKey:                  "0",
+				NghttpxFrontendReadTimeoutKey:      "1 minute",
+				NghttpxBackendReadTimeoutKey:       "10s",
+				NghttpxRequestHeaderFieldBufferKey: "64KB",
+				NghttpxCiphersKey:                  "HIGH\nworkers=100",
+				NghttpxAccessLogFormatKey:          "$status\nworkers=100",
+			},
+			want: IngressConfig{
+				BackendReadTimeout: "10s",
+			},
+			wantErr: true,
+		},
+		{
+			desc: "min TLS version is greater than max",
+			data: map[string]string{
+				NghttpxTLSMinProtoVersionKey: "TLSv1.2",
+				NghttpxTLSMaxProtoVersionKey: "TLSv1.1",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		ingConfig := &IngressConfig{}
+
+		err := ReadConfig(ingConfig, &v1.ConfigMap{Data: tt.data})
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("%v: ReadConfig(...) = %v, want error = %v", tt.desc, err, want)
+		}
+
+		if got, want := *ingConfig, tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: ingConfig = %+v, want %+v", tt.desc, got, want)
+		}
+	}
+}
+
+// TestFilterExtraConfig verifies that filterExtraConfig removes options managed by the controller, the options not allowed in strict
+// mode, and the options which conflict with outlier detection and shared TLS session ticket keys.
+func TestFilterExtraConfig(t *testing.T) {
+	const extraConfig = `# comment
+log-level=INFO
+frontend=*,3000;api;no-tls
+workers = 8
+
+tls-dyn-rec-warmup-threshold=0
+no-such-option=foo
+include=/etc/nghttpx/other.conf
+accesslog-file=/var/log/nghttpx/access.log
+tls-ticket-key-cipher=aes-256-cbc
+tls-ticket-key-memcached=127.0.0.1,11211
+accesslog-syslog=yes`
+
+	tests := []struct {
+		desc              string
+		strict            bool
+		outlierDetection  bool
+		shareTLSTicketKey bool
+		want              string
+		wantErrs          int
+	}{
+		{
+			desc: "permissive",
+			want: `# comment
+log-level=INFO
+
+tls-dyn-rec-warmup-threshold=0
+no-such-option=foo
+accesslog-file=/var/log/nghttpx/access.log
+tls-ticket-key-cipher=aes-256-cbc
+tls-ticket-key-memcached=127.0.0.1,11211
+accesslog-syslog=yes`,
+			wantErrs: 3,
+		},
+		{
+			desc:   "strict",
+			strict: true,
+			want: `# comment
+log-level=INFO
+
+tls-dyn-rec-warmup-threshold=0
+accesslog-file=/var/log/nghttpx/access.log
+tls-ticket-key-cipher=aes-256-cbc
+tls-ticket-key-memcached=127.0.0.1,11211
+accesslog-syslog=yes`,
+			wantErrs: 4,
+		},
+		{
+			desc:             "outlier detection",
+			outlierDetection: true,
+			want: `# comment
+log-level=INFO
+
+tls-dyn-rec-warmup-threshold=0
+no-such-option=foo
+tls-ticket-key-cipher=aes-256-cbc
+tls-ticket-key-memcached=127.0.0.1,11211`,
+			wantErrs: 5,
+		},
+		{
+			desc:              "shared TLS session ticket keys",
+			shareTLSTicketKey: true,
+			want: `# comment
+log-level=INFO
+
+tls-dyn-rec-warmup-threshold=0
+no-such-option=foo
+accesslog-file=/var/log/nghttpx/access.log
+accesslog-syslog=yes`,
+			wantErrs: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		got, errs := filterExtraConfig(extraConfig, tt.strict, tt.outlierDetection, tt.shareTLSTicketKey)
+		if want := tt.want; got != want {
+			t.Errorf("%v: filterExtraConfig(...) = %q, want %q", tt.desc, got, want)
+		}
+		if got, want := len(errs), tt.wantErrs; got != want {
+			t.Errorf("%v: len(errs) = %v, want %v: %v", tt.desc, got, want, errs)
+		}
+	}
+
+	// Configuration which contains no controller managed options must be left unchanged.
+	if got, errs := filterExtraConfig("log-level=INFO\n", false, false, false); got != "log-level=INFO\n" || len(errs) != 0 {
+		t.Errorf("filterExtraConfig(...) = %q, %v, want %q, nil", got, errs, "log-level=INFO\n")
+	}
+}
+
+// TestParseFrontends verifies ParseFrontends.
+func TestParseFrontends(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Frontend
+		wantErr bool
+	}{
+		{
+			in: "*,80\n\n10.0.0.1,443;tls;proxyproto\n::1,8080;proxyproto\n",
+			want: []Frontend{
+				{Host: "*", Port: 80},
+				{Host: "10.0.0.1", Port: 443, TLS: true, ProxyProto: true},
+				{Host: "::1", Port: 8080, ProxyProto: true},
+			},
+		},
+		{
+			in:      "",
+			wantErr: true,
+		},
+		{
+			in:      "*",
+			wantErr: true,
+		},
+		{
+			in:      "localhost,80",
+			wantErr: true,
+		},
+		{
+			in:      "*,65536",
+			wantErr: true,
+		},
+		{
+			in:      "*,443;api",
+			wantErr: true,
+		},
+		{
+			in:      "*,80\n*,80;tls",
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		got, err := ParseFrontends(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%v: ParseFrontends(%q) succeeded, want error", i, tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: ParseFrontends(%q): %v", i, tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%v: ParseFrontends(%q) = %+v, want %+v", i, tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestRemoveUnsupportedOptions verifies RemoveUnsupportedOptions.
+func TestRemoveUnsupportedOptions(t *testing.T) {
+	ingConfig := &IngressConfig{
+		BackendReadTimeout: "30s",
+		TLSMinProtoVersion: "TLSv1.2",
+	}
+	caps := &Capabilities{
+		Version: "1.20.0",
+		Options: map[string]bool{
+			NghttpxBackendReadTimeoutKey: true,
+		},
+	}
+
+	if err := RemoveUnsupportedOptions(ingConfig, caps); err == nil {
+		t.Errorf("RemoveUnsupportedOptions(...) succeeded, want error")
+	}
+
+	if got, want := ingConfig.BackendReadTimeout, "30s"; got != want {
+		t.Errorf("ingConfig.BackendReadTimeout = %v, want %v", got, want)
+	}
+	if got, want := ingConfig.TLSMinProtoVersion, ""; got != want {
+		t.Errorf("ingConfig.TLSMinProtoVersion = %v, want %v", got, want)
+	}
+
+	caps.Options[NghttpxTLSMinProtoVersionKey] = true
+	ingConfig.TLSMinProtoVersion = "TLSv1.2"
+
+	if err := RemoveUnsupportedOptions(ingConfig, caps); err != nil {
+		t.Errorf("RemoveUnsupportedOptions(...): %v", err)
+	}
+	if got, want := ingConfig.TLSMinProtoVersion, "TLSv1.2"; got != want {
+		t.Errorf("ingConfig.TLSMinProtoVersion = %v, want %v", got, want)
+	}
+}
+
+// TestRemoveUnsupportedFeatures verifies that RemoveUnsupportedOptions disables the features which nghttpx does not support.
+func TestRemoveUnsupportedFeatures(t *testing.T) {
+	frontends := []Frontend{{Host: "*", Port: 80, ProxyProto: true}}
+	upstreams := []*Upstream{{Host: "alpha.test", RejectTLS: true}, {Host: "bravo.test"}}
+	ingConfig := &IngressConfig{
+		Frontends:               frontends,
+		OutlierDetection:        true,
+		ClientCertSubjectHeader: DefaultClientCertSubjectHeader,
+		Upstreams:               upstreams,
+		RejectTLSMrubyFile:      CreateRejectTLSMrubyFile("conf"),
+	}
+	caps := &Capabilities{Version: "1.16.0"}
+
+	err := RemoveUnsupportedOptions(ingConfig, caps)
+	if err == nil {
+		t.Fatalf("RemoveUnsupportedOptions(...) succeeded, want error")
+	}
+	// Outlier detection and RejectTLS are not configured by ConfigMap, and they are disabled without error.
+	if got, want := len(err.(utilerrors.Aggregate).Errors()), 2; got != want {
+		t.Errorf("len(err.Errors()) = %v, want %v: %v", got, want, err)
+	}
+
+	if got, want := ingConfig.Frontends, []Frontend{{Host: "*", Port: 80}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ingConfig.Frontends = %+v, want %+v", got, want)
+	}
+	if !frontends[0].ProxyProto {
+		t.Errorf("The original frontends must not be modified")
+	}
+	if ingConfig.OutlierDetection {
+		t.Errorf("ingConfig.OutlierDetection = true, want false")
+	}
+	if got, want := ingConfig.ClientCertSubjectHeader, ""; got != want {
+		t.Errorf("ingConfig.ClientCertSubjectHeader = %v, want %v", got, want)
+	}
+	if got, want := ingConfig.Upstreams, []*Upstream{{Host: "alpha.test"}, {Host: "bravo.test"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ingConfig.Upstreams = %+v, want %+v", got, want)
+	}
+	if !upstreams[0].RejectTLS {
+		t.Errorf("The original upstreams must not be modified")
+	}
+	if ingConfig.RejectTLSMrubyFile != nil {
+		t.Errorf("ingConfig.RejectTLSMrubyFile = %+v, want nil", ingConfig.RejectTLSMrubyFile)
+	}
+}